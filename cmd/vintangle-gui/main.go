@@ -4,7 +4,8 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -14,11 +15,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"path"
+	"os/user"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
@@ -32,6 +35,11 @@ import (
 	"github.com/phayes/freeport"
 	"github.com/pojntfx/htorrent/pkg/client"
 	"github.com/pojntfx/htorrent/pkg/server"
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/pojntfx/vintangle/pkg/controls"
+	"github.com/pojntfx/vintangle/pkg/party"
+	"github.com/pojntfx/vintangle/pkg/player"
+	"github.com/pojntfx/vintangle/pkg/subtitle"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -46,14 +54,14 @@ type media struct {
 type mediaWithPriority struct {
 	media
 	priority int
+	language string
+	score    float64
 }
 
-type mpvCommand struct {
-	Command []interface{} `json:"command"`
-}
-
-type mpvFloat64Response struct {
-	Data float64 `json:"data"`
+type downloadProgress struct {
+	peers     int
+	total     int64
+	completed int64
 }
 
 var (
@@ -97,8 +105,12 @@ var (
 
 	json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-	errKilled            = errors.New("signal: killed")
-	errNoWorkingMPVFound = errors.New("could not find working a working mpv")
+	downloadProgresses     = map[string]downloadProgress{}
+	downloadProgressesLock sync.Mutex
+
+	errInvalidScheduleTime = errors.New("could not parse schedule time, expected HH:MM or HH:MM:SS")
+
+	mediaExtensions = []string{".mkv", ".mp4", ".m4v", ".webm", ".avi", ".mov", ".wmv", ".flv", ".mpg", ".mpeg", ".ts", ".m2ts", ".ogv", ".3gp", ".mp3", ".flac", ".ogg", ".opus", ".m4a", ".wav", ".aac"}
 )
 
 const (
@@ -116,22 +128,66 @@ const (
 
 	verboseFlag = "verbose"
 	storageFlag = "storage"
+	playerFlag  = "player"
 	mpvFlag     = "mpv"
+	vlcFlag     = "vlc"
+
+	mpvPlayer = "mpv"
+	vlcPlayer = "vlc"
 
 	gatewayRemoteFlag   = "gatewayremote"
 	gatewayURLFlag      = "gatewayurl"
 	gatewayUsernameFlag = "gatewayusername"
 	gatewayPasswordFlag = "gatewaypassword"
 
+	partyServerFlag = "partyserver"
+	partyRoomFlag   = "partyroom"
+	partyNameFlag   = "partyname"
+
+	partyHeartbeatInterval = time.Second * 5
+	partyHeartbeatTimeout  = time.Second * 12
+	partyPositionInterval  = time.Second
+	partyPresenceInterval  = time.Second * 2
+	presenceDriftThreshold = time.Second * 2
+	partyClockSyncInterval = time.Second * 30
+	partyClockSamples      = 16
+
+	syncSpeedThresholdFlag = "syncspeedthreshold"
+	syncSeekThresholdFlag  = "syncseekthreshold"
+
+	syncBufferTimeoutFlag = "syncbuffertimeout"
+	partyChatOSDFlag      = "partychatosd"
+	partyPeerToPeerFlag   = "partypeertopeer"
+	partySTUNServersFlag  = "partystunservers"
+
+	subtitleStylesFlag   = "subtitlestyles"
+	subtitleLanguageFlag = "subtitlelanguage"
+
+	syncMaxSpeedOffset = 0.05
+	syncCatchUpTime    = time.Second * 10
+	syncReadyCache     = time.Second * 5
+
+	reactionDuration = time.Second * 4
+	reactionSlots    = 5
+
+	playerTimeout = time.Second * 5
+
+	subtitlesCacheDirName = ".subtitles"
+
 	keycodeEscape = 66
 
 	schemaDirEnvVar = "GSETTINGS_SCHEMA_DIR"
 
 	preferencesActionName      = "preferences"
 	applyPreferencesActionName = "applypreferences"
+	approveRequestActionName   = "approverequest"
+	addSuggestionActionName    = "addsuggestion"
+	admitMemberActionName      = "admitmember"
+	reactActionName            = "react"
 
 	mpvFlathubURL = "https://flathub.org/apps/details/io.mpv.Mpv"
 	mpvWebsiteURL = "https://mpv.io/installation/"
+	vlcWebsiteURL = "https://www.videolan.org/vlc/"
 
 	issuesURL = "https://github.com/pojntfx/vintangle/issues"
 )
@@ -176,6 +232,25 @@ func formatDuration(duration time.Duration) string {
 	return fmt.Sprintf("%02d:%02d:%02d", int(hours), int(minutes), int(seconds))
 }
 
+// parseScheduleTime returns the next occurrence of the given local wall clock time after `now`
+func parseScheduleTime(input string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		t, err := time.ParseInLocation(layout, strings.TrimSpace(input), now.Location())
+		if err != nil {
+			continue
+		}
+
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+
+		return at, nil
+	}
+
+	return time.Time{}, errInvalidScheduleTime
+}
+
 func getDisplayPathWithoutRoot(p string) string {
 	parts := strings.Split(p, "/") // Incoming paths are always UNIX
 
@@ -186,31 +261,185 @@ func getDisplayPathWithoutRoot(p string) string {
 	return filepath.Join(parts[1:]...) // Outgoing paths are OS-specific (display only)
 }
 
-func findWorkingMPV() (string, error) {
-	if _, err := os.Stat("/.flatpak-info"); err == nil {
-		if err := exec.Command("flatpak-spawn", "--host", "mpv", "--version").Run(); err == nil {
-			return "flatpak-spawn --host mpv", nil
+func getTrackTitle(track player.Track) string {
+	if strings.TrimSpace(track.Title) != "" {
+		return track.Title
+	}
+
+	if strings.TrimSpace(track.Lang) != "" {
+		return track.Lang
+	}
+
+	return fmt.Sprintf("Track %v", track.ID)
+}
+
+func getTrackDescription(track player.Track) string {
+	parts := []string{}
+	if strings.TrimSpace(track.Title) != "" && strings.TrimSpace(track.Lang) != "" {
+		parts = append(parts, track.Lang)
+	}
+
+	if strings.TrimSpace(track.Codec) != "" {
+		parts = append(parts, track.Codec)
+	}
+
+	if track.Default {
+		parts = append(parts, "Default")
+	}
+
+	if track.Forced {
+		parts = append(parts, "Forced")
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// getTracksKey identifies the tracks regardless of which ones are selected, which allows us to only rebuild the track lists if the tracks have changed
+func getTracksKey(tracks []player.Track) string {
+	key := ""
+	for _, track := range tracks {
+		key += fmt.Sprintf("%v/%v/%v/%v/%v/%v/%v/%v\n", track.ID, track.Type, track.Title, track.Lang, track.Codec, track.Default, track.Forced, track.External)
+	}
+
+	return key
+}
+
+// isMedia returns true if the file at the path can be played, as opposed to i.e. subtitles, covers or info files
+func isMedia(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	for _, candidate := range mediaExtensions {
+		if ext == candidate {
+			return true
 		}
+	}
+
+	return false
+}
+
+// getSubtitles returns the subtitle files from the media, best match first, followed by the other files
+func getSubtitles(torrentMedia []media, selectedTorrentMedia string, preferredLanguage string) []mediaWithPriority {
+	sizes := map[string]int{}
+	files := []string{}
+	for _, m := range torrentMedia {
+		sizes[m.name] = m.size
+		files = append(files, m.name)
+	}
+
+	subtitles := []mediaWithPriority{}
+	for _, candidate := range subtitle.Rank(selectedTorrentMedia, files, preferredLanguage) {
+		subtitles = append(subtitles, mediaWithPriority{
+			media: media{
+				name: candidate.Path,
+				size: sizes[candidate.Path],
+			},
+			priority: 0,
+			language: candidate.Language,
+			score:    candidate.Score,
+		})
+	}
 
-		if err := exec.Command("flatpak-spawn", "--host", "flatpak", "run", "io.mpv.Mpv", "--version").Run(); err == nil {
-			return "flatpak-spawn --host flatpak run io.mpv.Mpv", nil
+	for _, m := range torrentMedia {
+		if m.name != selectedTorrentMedia && !subtitle.IsSubtitles(m.name) {
+			subtitles = append(subtitles, mediaWithPriority{
+				media:    m,
+				priority: 1,
+			})
 		}
+	}
+
+	return subtitles
+}
+
+// getPreferredSubtitleLanguage returns the configured subtitle language, falling back to the system language
+func getPreferredSubtitleLanguage(settings *gio.Settings) string {
+	if language := strings.TrimSpace(settings.String(subtitleLanguageFlag)); language != "" {
+		return strings.ToLower(language)
+	}
+
+	return subtitle.SystemLanguage()
+}
+
+func setDownloadProgress(path string, progress downloadProgress) {
+	downloadProgressesLock.Lock()
+	defer downloadProgressesLock.Unlock()
+
+	downloadProgresses[path] = progress
+}
+
+func getDownloadProgress(path string) (downloadProgress, bool) {
+	downloadProgressesLock.Lock()
+	defer downloadProgressesLock.Unlock()
+
+	progress, ok := downloadProgresses[path]
+
+	return progress, ok
+}
+
+// escapeASS prevents text from being interpreted as ASS override tags
+func escapeASS(text string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		"{", "\\{",
+		"}", "\\}",
+		"\n", " ",
+	).Replace(text)
+}
+
+func getPartyName(settings *gio.Settings) string {
+	if name := settings.String(partyNameFlag); strings.TrimSpace(name) != "" {
+		return name
+	}
+
+	if u, err := user.Current(); err == nil && strings.TrimSpace(u.Username) != "" {
+		return u.Username
+	}
+
+	return "Anonymous"
+}
+
+// findWorkingPlayer configures the selected player if it hasn't been configured yet, falling back to the other ones if it can't be found
+func findWorkingPlayer(settings *gio.Settings) error {
+	commandFlags := map[string]string{
+		mpvPlayer: mpvFlag,
+		vlcPlayer: vlcFlag,
+	}
+
+	finders := map[string]func() (string, error){
+		mpvPlayer: player.FindWorkingMPV,
+		vlcPlayer: player.FindWorkingVLC,
+	}
 
-		return "", errNoWorkingMPVFound
+	selectedPlayer := settings.String(playerFlag)
+	if _, ok := commandFlags[selectedPlayer]; !ok {
+		selectedPlayer = mpvPlayer
 	}
 
-	if err := exec.Command("mpv", "--version").Run(); err == nil {
-		return "mpv", nil
+	if strings.TrimSpace(settings.String(commandFlags[selectedPlayer])) != "" {
+		return nil
 	}
 
-	if err := exec.Command("flatpak", "run", "io.mpv.Mpv", "--version").Run(); err == nil {
-		return "flatpak run io.mpv.Mpv", nil
+	for _, candidate := range []string{selectedPlayer, mpvPlayer, vlcPlayer} {
+		command, err := finders[candidate]()
+		if err != nil {
+			continue
+		}
+
+		log.Info().
+			Str("player", candidate).
+			Str("command", command).
+			Msg("Found working player")
+
+		settings.SetString(playerFlag, candidate)
+		settings.SetString(commandFlags[candidate], command)
+		settings.Apply()
+
+		return nil
 	}
 
-	return "", errNoWorkingMPVFound
+	return player.ErrNoWorkingPlayer
 }
 
-func openAssistantWindow(ctx context.Context, app *adw.Application, manager *client.Manager, apiAddr, apiUsername, apiPassword string, settings *gio.Settings, gateway *server.Gateway, cancel func(), tmpDir string) error {
+func openAssistantWindow(ctx context.Context, app *adw.Application, manager *client.Manager, apiAddr, apiUsername, apiPassword string, settings *gio.Settings, gateway *server.Gateway, cancel func(), tmpDir string, initialInput string) error {
 	app.StyleManager().SetColorScheme(adw.ColorSchemeDefault)
 
 	builder := gtk.NewBuilderFromString(assistantUI, len(assistantUI))
@@ -239,6 +468,7 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 	warningDialog := warningBuilder.GetObject("warning-dialog").Cast().(*gtk.MessageDialog)
 	mpvFlathubDownloadButton := warningBuilder.GetObject("mpv-download-flathub-button").Cast().(*gtk.Button)
 	mpvWebsiteDownloadButton := warningBuilder.GetObject("mpv-download-website-button").Cast().(*gtk.Button)
+	vlcWebsiteDownloadButton := warningBuilder.GetObject("vlc-download-website-button").Cast().(*gtk.Button)
 	mpvManualConfigurationButton := warningBuilder.GetObject("mpv-manual-configuration-button").Cast().(*gtk.Button)
 
 	torrentTitle := ""
@@ -281,6 +511,31 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 			go func() {
 				magnetLink := magnetLinkEntry.Text()
 
+				var invite *party.Invite
+				if party.IsInvite(magnetLink) {
+					i, err := party.ParseInvite(magnetLink)
+					if err != nil {
+						log.Warn().
+							Str("invite", magnetLink).
+							Err(err).
+							Msg("Could not parse invite")
+
+						toast := adw.NewToast("Could not parse this invite.")
+
+						overlay.AddToast(toast)
+
+						headerbarSpinner.SetSpinning(false)
+						magnetLinkEntry.SetSensitive(true)
+
+						magnetLinkEntry.GrabFocus()
+
+						return
+					}
+
+					invite = &i
+					magnetLink = i.Magnet
+				}
+
 				log.Info().
 					Str("magnetLink", magnetLink).
 					Msg("Getting info for magnet link")
@@ -314,6 +569,30 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 					})
 				}
 
+				if invite != nil {
+					log.Info().
+						Str("path", invite.Path).
+						Str("room", invite.Room).
+						Msg("Joining party from invite")
+
+					glib.IdleAdd(func() {
+						keyring, err := party.NewKeyring(invite.Secret, invite.Room)
+						if err != nil {
+							openErrorDialog(ctx, window, err)
+
+							return
+						}
+
+						window.Close()
+
+						if err := openControlsWindow(ctx, app, torrentTitle, getSubtitles(torrentMedia, invite.Path, getPreferredSubtitleLanguage(settings)), torrentReadme, manager, apiAddr, apiUsername, apiPassword, *invite, randSeq(20), party.NewQueue(), keyring, settings, gateway, cancel, tmpDir); err != nil {
+							openErrorDialog(ctx, window, err)
+						}
+					})
+
+					return
+				}
+
 				for _, row := range mediaRows {
 					mediaSelectionGroup.Remove(row)
 				}
@@ -441,27 +720,26 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 	})
 
 	playButton.ConnectClicked(func() {
-		window.Close()
+		subtitles = getSubtitles(torrentMedia, selectedTorrentMedia, getPreferredSubtitleLanguage(settings))
 
-		subtitles = []mediaWithPriority{}
-		for _, media := range torrentMedia {
-			if media.name != selectedTorrentMedia {
-				if strings.HasSuffix(media.name, ".srt") || strings.HasSuffix(media.name, ".vtt") || strings.HasSuffix(media.name, ".ass") {
-					subtitles = append(subtitles, mediaWithPriority{
-						media:    media,
-						priority: 0,
-					})
-				} else {
-					subtitles = append(subtitles, mediaWithPriority{
-						media:    media,
-						priority: 1,
-					})
-				}
-			}
+		invite, err := party.NewInvite(magnetLinkEntry.Text(), selectedTorrentMedia, settings.String(partyServerFlag), settings.String(partyRoomFlag))
+		if err != nil {
+			openErrorDialog(ctx, window, err)
+
+			return
 		}
 
-		if err := openControlsWindow(ctx, app, torrentTitle, subtitles, selectedTorrentMedia, torrentReadme, manager, apiAddr, apiUsername, apiPassword, magnetLinkEntry.Text(), settings, gateway, cancel, tmpDir); err != nil {
-			panic(err)
+		keyring, err := party.NewKeyring(invite.Secret, invite.Room)
+		if err != nil {
+			openErrorDialog(ctx, window, err)
+
+			return
+		}
+
+		window.Close()
+
+		if err := openControlsWindow(ctx, app, torrentTitle, subtitles, torrentReadme, manager, apiAddr, apiUsername, apiPassword, invite, invite.Host, party.NewQueue(), keyring, settings, gateway, cancel, tmpDir); err != nil {
+			openErrorDialog(ctx, window, err)
 		}
 	})
 
@@ -488,6 +766,14 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 		})
 	})
 
+	vlcWebsiteDownloadButton.ConnectClicked(func() {
+		gtk.ShowURIFull(ctx, &window.Window, vlcWebsiteURL, gdk.CURRENT_TIME, func(res gio.AsyncResulter) {
+			warningDialog.Close()
+
+			os.Exit(0)
+		})
+	})
+
 	mpvManualConfigurationButton.ConnectClicked(func() {
 		warningDialog.Close()
 
@@ -506,16 +792,10 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 	app.AddWindow(&window.Window)
 
 	window.ConnectShow(func() {
-		if oldMPVCommand := settings.String(mpvFlag); strings.TrimSpace(oldMPVCommand) == "" {
-			newMPVCommand, err := findWorkingMPV()
-			if err != nil {
-				warningDialog.Show()
-
-				return
-			}
+		if err := findWorkingPlayer(settings); err != nil {
+			warningDialog.Show()
 
-			settings.SetString(mpvFlag, newMPVCommand)
-			settings.Apply()
+			return
 		}
 
 		magnetLinkEntry.GrabFocus()
@@ -523,12 +803,22 @@ func openAssistantWindow(ctx context.Context, app *adw.Application, manager *cli
 
 	window.Show()
 
+	// Skip the welcome page if we've been opened with a magnet link or invite
+	if strings.TrimSpace(initialInput) != "" {
+		magnetLinkEntry.SetText(initialInput)
+
+		onNext()
+	}
+
 	return nil
 }
 
-func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle string, subtitles []mediaWithPriority, selectedTorrentMedia, torrentReadme string, manager *client.Manager, apiAddr, apiUsername, apiPassword, magnetLink string, settings *gio.Settings, gateway *server.Gateway, cancel func(), tmpDir string) error {
+func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle string, subtitles []mediaWithPriority, torrentReadme string, manager *client.Manager, apiAddr, apiUsername, apiPassword string, invite party.Invite, partyMemberID string, queue *party.Queue, keyring *party.Keyring, settings *gio.Settings, gateway *server.Gateway, cancel func(), tmpDir string) error {
 	app.StyleManager().SetColorScheme(adw.ColorSchemePreferDark)
 
+	magnetLink := invite.Magnet
+	selectedTorrentMedia := invite.Path
+
 	builder := gtk.NewBuilderFromString(controlsUI, len(controlsUI))
 
 	window := builder.GetObject("main-window").Cast().(*adw.ApplicationWindow)
@@ -540,12 +830,51 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 	volumeButton := builder.GetObject("volume-button").Cast().(*gtk.VolumeButton)
 	subtitleButton := builder.GetObject("subtitle-button").Cast().(*gtk.Button)
 	fullscreenButton := builder.GetObject("fullscreen-button").Cast().(*gtk.ToggleButton)
+	tracksButton := builder.GetObject("tracks-button").Cast().(*gtk.MenuButton)
+	audioTracksList := builder.GetObject("audio-tracks-list").Cast().(*gtk.ListBox)
+	videoTracksLabel := builder.GetObject("video-tracks-label").Cast().(*gtk.Label)
+	videoTracksList := builder.GetObject("video-tracks-list").Cast().(*gtk.ListBox)
 	mediaInfoButton := builder.GetObject("media-info-button").Cast().(*gtk.Button)
 	menuButton := builder.GetObject("menu-button").Cast().(*gtk.MenuButton)
 	copyButton := builder.GetObject("copy-button").Cast().(*gtk.Button)
 	elapsedTrackLabel := builder.GetObject("elapsed-track-label").Cast().(*gtk.Label)
 	remainingTrackLabel := builder.GetObject("remaining-track-label").Cast().(*gtk.Label)
 	seeker := builder.GetObject("seeker").Cast().(*gtk.Scale)
+	bufferingRevealer := builder.GetObject("buffering-revealer").Cast().(*gtk.Revealer)
+	bufferingLabel := builder.GetObject("buffering-label").Cast().(*gtk.Label)
+	bufferingSkipButton := builder.GetObject("buffering-skip-button").Cast().(*gtk.Button)
+	partyButton := builder.GetObject("party-button").Cast().(*gtk.MenuButton)
+	chatButton := builder.GetObject("chat-button").Cast().(*gtk.MenuButton)
+	chatPopover := builder.GetObject("chat-popover").Cast().(*gtk.Popover)
+	chatScrolledWindow := builder.GetObject("chat-scrolled-window").Cast().(*gtk.ScrolledWindow)
+	chatMessagesList := builder.GetObject("chat-messages-list").Cast().(*gtk.ListBox)
+	chatInput := builder.GetObject("chat-input").Cast().(*gtk.Entry)
+	chatSendButton := builder.GetObject("chat-send-button").Cast().(*gtk.Button)
+	reactionsButton := builder.GetObject("reactions-button").Cast().(*gtk.MenuButton)
+	reactionsPopover := builder.GetObject("reactions-popover").Cast().(*gtk.Popover)
+	queueButton := builder.GetObject("queue-button").Cast().(*gtk.MenuButton)
+	queueEmptyLabel := builder.GetObject("queue-empty-label").Cast().(*gtk.Label)
+	queueScrolledWindow := builder.GetObject("queue-scrolled-window").Cast().(*gtk.ScrolledWindow)
+	queueList := builder.GetObject("queue-list").Cast().(*gtk.ListBox)
+	queueMagnetInput := builder.GetObject("queue-magnet-input").Cast().(*gtk.Entry)
+	queueBrowseButton := builder.GetObject("queue-browse-button").Cast().(*gtk.Button)
+	queueFilesScrolledWindow := builder.GetObject("queue-files-scrolled-window").Cast().(*gtk.ScrolledWindow)
+	queueFilesList := builder.GetObject("queue-files-list").Cast().(*gtk.ListBox)
+	voteButton := builder.GetObject("vote-button").Cast().(*gtk.MenuButton)
+	votePopover := builder.GetObject("vote-popover").Cast().(*gtk.Popover)
+	voteStatusLabel := builder.GetObject("vote-status-label").Cast().(*gtk.Label)
+	voteScrolledWindow := builder.GetObject("vote-scrolled-window").Cast().(*gtk.ScrolledWindow)
+	voteList := builder.GetObject("vote-list").Cast().(*gtk.ListBox)
+	voteStartButton := builder.GetObject("vote-start-button").Cast().(*gtk.Button)
+	voteEndButton := builder.GetObject("vote-end-button").Cast().(*gtk.Button)
+	scheduleButton := builder.GetObject("schedule-button").Cast().(*gtk.MenuButton)
+	scheduleTimeInput := builder.GetObject("schedule-time-input").Cast().(*gtk.Entry)
+	scheduleStartButton := builder.GetObject("schedule-start-button").Cast().(*gtk.Button)
+	scheduleCancelButton := builder.GetObject("schedule-cancel-button").Cast().(*gtk.Button)
+	countdownLabel := builder.GetObject("countdown-label").Cast().(*gtk.Label)
+	partyRestrictBox := builder.GetObject("party-restrict-box").Cast().(*gtk.Box)
+	partyRestrictSwitch := builder.GetObject("party-restrict-switch").Cast().(*gtk.Switch)
+	partyMembersList := builder.GetObject("party-members-list").Cast().(*gtk.ListBox)
 
 	descriptionBuilder := gtk.NewBuilderFromString(descriptionUI, len(descriptionUI))
 	descriptionWindow := descriptionBuilder.GetObject("description-window").Cast().(*adw.Window)
@@ -557,6 +886,11 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 	subtitlesOKButton := subtitlesBuilder.GetObject("button-ok").Cast().(*gtk.Button)
 	subtitlesSelectionGroup := subtitlesBuilder.GetObject("subtitle-tracks").Cast().(*adw.PreferencesGroup)
 	addSubtitlesFromFileButton := subtitlesBuilder.GetObject("add-from-file-button").Cast().(*gtk.Button)
+	subtitleStyleResetButton := subtitlesBuilder.GetObject("subtitle-style-reset-button").Cast().(*gtk.Button)
+	subtitleDelayInput := subtitlesBuilder.GetObject("subtitle-delay-input").Cast().(*gtk.SpinButton)
+	subtitleScaleInput := subtitlesBuilder.GetObject("subtitle-scale-input").Cast().(*gtk.SpinButton)
+	subtitlePositionInput := subtitlesBuilder.GetObject("subtitle-position-input").Cast().(*gtk.SpinButton)
+	subtitleFontInput := subtitlesBuilder.GetObject("subtitle-font-input").Cast().(*gtk.Entry)
 
 	preparingBuilder := gtk.NewBuilderFromString(preparingUI, len(preparingUI))
 	preparingWindow := preparingBuilder.GetObject("preparing-window").Cast().(*adw.Window)
@@ -566,13 +900,13 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 	buttonHeaderbarSubtitle.SetLabel(getDisplayPathWithoutRoot(selectedTorrentMedia))
 
 	copyButton.ConnectClicked(func() {
-		window.Clipboard().SetText(magnetLink)
+		window.Clipboard().SetText(invite.String())
 	})
 
 	stopButton.ConnectClicked(func() {
 		window.Close()
 
-		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir); err != nil {
+		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir, ""); err != nil {
 			openErrorDialog(ctx, window, err)
 
 			return
@@ -622,84 +956,78 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 
 		window.Close()
 
-		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir); err != nil {
+		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir, ""); err != nil {
 			openErrorDialog(ctx, window, err)
 
 			return
 		}
 	})
 
-	usernameAndPassword := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", apiUsername, apiPassword)))
-
 	streamURL, err := getStreamURL(apiAddr, magnetLink, selectedTorrentMedia)
 	if err != nil {
 		return err
 	}
 
-	ipcDir, err := os.MkdirTemp(os.TempDir(), "mpv-ipc")
-	if err != nil {
-		return err
-	}
-
-	ipcFile := filepath.Join(ipcDir, "mpv.sock")
-
-	shell := []string{"sh", "-c"}
-	if runtime.GOOS == "windows" {
-		shell = []string{"cmd", "/c"}
-	}
-	commandLine := append(shell, fmt.Sprintf("%v '--keep-open=always' '--no-osc' '--no-input-default-bindings' '--pause' '--input-ipc-server=%v' '--http-header-fields=Authorization: Basic %v' '%v'", settings.String(mpvFlag), ipcFile, usernameAndPassword, streamURL))
-
-	command := exec.Command(
-		commandLine[0],
-		commandLine[1:]...,
-	)
-	if runtime.GOOS != "windows" {
-		command.SysProcAttr = &syscall.SysProcAttr{
-			Setsid: true,
-		}
+	var mediaPlayer player.Player
+	switch settings.String(playerFlag) {
+	case vlcPlayer:
+		mediaPlayer = player.NewVLC(settings.String(vlcFlag), playerTimeout, ctx)
+	default:
+		mediaPlayer = player.NewMPV(settings.String(mpvFlag), playerTimeout, ctx)
 	}
 
 	addMainMenu(ctx, app, window, settings, menuButton, overlay, gateway, func() {
 		cancel()
 
-		if command.Process != nil {
-			if err := command.Process.Kill(); err != nil {
-				openErrorDialog(ctx, window, err)
+		if err := mediaPlayer.Close(); err != nil {
+			openErrorDialog(ctx, window, err)
 
-				return
-			}
+			return
 		}
 	})
 
 	app.AddWindow(&window.Window)
 
-	window.ConnectShow(func() {
-		preparingWindow.Show()
+	var partyClient *party.Client
+	closeParty := func() {
+		if partyClient == nil {
+			return
+		}
 
-		if err := command.Start(); err != nil {
-			openErrorDialog(ctx, window, err)
+		if err := partyClient.Close(); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not close party client")
+		}
+
+		partyClient = nil
+	}
 
+	broadcast := func(messageType string, payload interface{}) {
+		if partyClient == nil {
 			return
 		}
 
-		window.ConnectCloseRequest(func() (ok bool) {
-			if command.Process != nil {
-				if runtime.GOOS == "windows" {
-					if err := command.Process.Kill(); err != nil {
-						openErrorDialog(ctx, window, err)
+		if err := partyClient.Send(messageType, payload); err != nil {
+			log.Warn().
+				Str("type", messageType).
+				Err(err).
+				Msg("Could not send party message")
+		}
+	}
 
-						return false
-					}
-				} else {
-					if err := syscall.Kill(-command.Process.Pid, syscall.SIGKILL); err != nil {
-						openErrorDialog(ctx, window, err)
+	window.ConnectShow(func() {
+		preparingWindow.Show()
 
-						return false
-					}
-				}
-			}
+		// Media switches finish asynchronously, so we have to be able to cancel them
+		pendingMediaSwitch := ""
 
-			if err := os.RemoveAll(ipcDir); err != nil {
+		window.ConnectCloseRequest(func() (ok bool) {
+			pendingMediaSwitch = ""
+
+			closeParty()
+
+			if err := mediaPlayer.Close(); err != nil {
 				openErrorDialog(ctx, window, err)
 
 				return false
@@ -708,30 +1036,47 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 			return true
 		})
 
-		var sock net.Conn
-		for {
-			sock, err = net.Dial("unix", ipcFile)
-			if err == nil {
-				break
+		// Converted subtitles are kept next to the media so that they don't have to be downloaded and converted again
+		magnetHash := sha1.Sum([]byte(magnetLink))
+		playerControls := controls.NewControls(mediaPlayer, filepath.Join(settings.String(storageFlag), subtitlesCacheDirName, hex.EncodeToString(magnetHash[:])), func(m string) (io.ReadCloser, error) {
+			streamURL, err := getStreamURL(apiAddr, magnetLink, m)
+			if err != nil {
+				return nil, err
 			}
 
-			time.Sleep(time.Millisecond * 100)
+			hc := &http.Client{}
 
-			log.Error().
-				Str("path", ipcFile).
-				Err(err).
-				Msg("Could not dial IPC socket, retrying in 100ms")
-		}
+			req, err := http.NewRequest(http.MethodGet, streamURL, http.NoBody)
+			if err != nil {
+				return nil, err
+			}
+			req.SetBasicAuth(apiUsername, apiPassword)
 
-		encoder := json.NewEncoder(sock)
-		if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "volume", 100}}); err != nil {
-			openErrorDialog(ctx, window, err)
+			res, err := hc.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			if res.StatusCode != http.StatusOK {
+				if res.Body != nil {
+					_ = res.Body.Close()
+				}
 
-			return
-		}
+				return nil, errors.New(res.Status)
+			}
+
+			return res.Body, nil
+		}, ctx)
 
 		activators := []*gtk.CheckButton{}
+		session := party.NewSession(partyMemberID, invite.Host)
+		roles, barrier, presences := session.Roles(), session.Barrier(), session.Presences()
+		canControl := func() bool {
+			return partyClient == nil || roles.CanControl(partyMemberID)
+		}
 
+		subtitleActivators := map[string]*gtk.CheckButton{}
+
+		var otherFilesRow *adw.ExpanderRow
 		for i, file := range append(
 			[]mediaWithPriority{
 				{media: media{
@@ -756,85 +1101,49 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 			activator.SetActive(false)
 			activator.ConnectActivate(func() {
 				if j == 0 {
-					log.Info().
-						Msg("Disabling subtitles")
-
-					if err := encoder.Encode(mpvCommand{[]interface{}{"change-list", "sub-files", "clr"}}); err != nil {
+					if err := playerControls.DisableSubtitles(); err != nil {
 						openErrorDialog(ctx, window, err)
 
 						return
 					}
 
-					return
-				}
-
-				streamURL, err := getStreamURL(apiAddr, magnetLink, m)
-				if err != nil {
-					openErrorDialog(ctx, window, err)
-
-					return
-				}
-
-				log.Info().
-					Str("streamURL", streamURL).
-					Msg("Downloading subtitles")
-
-				hc := &http.Client{}
-
-				req, err := http.NewRequest(http.MethodGet, streamURL, http.NoBody)
-				if err != nil {
-					openErrorDialog(ctx, window, err)
-
-					return
-				}
-				req.SetBasicAuth(apiUsername, apiPassword)
-
-				res, err := hc.Do(req)
-				if err != nil {
-					openErrorDialog(ctx, window, err)
-
-					return
-				}
-				if res.Body != nil {
-					defer res.Body.Close()
-				}
-				if res.StatusCode != http.StatusOK {
-					openErrorDialog(ctx, window, errors.New(res.Status))
-
-					return
-				}
-
-				subtitlesFile := filepath.Join(tmpDir, path.Base(m))
-				f, err := os.Create(subtitlesFile)
-				if err != nil {
-					openErrorDialog(ctx, window, err)
+					if canControl() {
+						broadcast(v1.TypeSelectSubtitles, v1.SelectSubtitles{})
+					}
 
 					return
 				}
 
-				if _, err := io.Copy(f, res.Body); err != nil {
+				if err := playerControls.SetSubtitles(m); err != nil {
 					openErrorDialog(ctx, window, err)
 
 					return
 				}
 
-				log.Info().
-					Str("path", subtitlesFile).
-					Msg("Setting subtitles")
-
-				if err := encoder.Encode(mpvCommand{[]interface{}{"change-list", "sub-files", "set", subtitlesFile}}); err != nil {
-					openErrorDialog(ctx, window, err)
-
-					return
+				if canControl() {
+					broadcast(v1.TypeSelectSubtitles, v1.SelectSubtitles{
+						Path: m,
+					})
 				}
 			})
 
+			if j == 0 {
+				subtitleActivators[""] = activator
+			} else {
+				subtitleActivators[m] = activator
+			}
+
 			if i == 0 {
 				row.SetTitle(file.name)
 				row.SetSubtitle("Disable subtitles")
 			} else if file.priority == 0 {
 				row.SetTitle(getDisplayPathWithoutRoot(file.name))
-				row.SetSubtitle("Integrated subtitle")
+
+				if file.language != "" {
+					row.SetSubtitle("Integrated subtitle · " + subtitle.LanguageName(file.language))
+				} else {
+					row.SetSubtitle("Integrated subtitle")
+				}
 			} else {
 				row.SetTitle(getDisplayPathWithoutRoot(file.name))
 				row.SetSubtitle("Extra file from media")
@@ -845,161 +1154,1987 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 			row.AddPrefix(activator)
 			row.SetActivatableWidget(activator)
 
+			// Files that don't look like subtitles are rarely what we want, so we hide them unless they are asked for
+			if file.priority == 1 {
+				if otherFilesRow == nil {
+					otherFilesRow = adw.NewExpanderRow()
+					otherFilesRow.SetTitle("Other files")
+					otherFilesRow.SetSubtitle("Files from the media that don't look like subtitles")
+
+					subtitlesSelectionGroup.Add(otherFilesRow)
+				}
+
+				otherFilesRow.AddRow(row)
+
+				continue
+			}
+
 			subtitlesSelectionGroup.Add(row)
 		}
 
-		seekerIsSeeking := false
+		// Embedded tracks are only known once the media has loaded, so we add them to the lists as they come in
+		renderedTracks := ""
+		audioTrackRows := []*adw.ActionRow{}
+		videoTrackRows := []*adw.ActionRow{}
+		embeddedSubtitleRows := []*adw.ActionRow{}
+		subtitleTrackActivators := map[int]*gtk.CheckButton{}
+		audioTrackActivators := map[int]*gtk.CheckButton{}
+		videoTrackActivators := map[int]*gtk.CheckButton{}
+		refreshTracks := func(tracks []player.Track) {
+			if key := getTracksKey(tracks); key != renderedTracks {
+				renderedTracks = key
+
+				for _, row := range audioTrackRows {
+					audioTracksList.Remove(row)
+				}
+				audioTrackRows = []*adw.ActionRow{}
+
+				for _, row := range videoTrackRows {
+					videoTracksList.Remove(row)
+				}
+				videoTrackRows = []*adw.ActionRow{}
+
+				for _, row := range embeddedSubtitleRows {
+					subtitlesSelectionGroup.Remove(row)
+				}
+				embeddedSubtitleRows = []*adw.ActionRow{}
+
+				subtitleTrackActivators = map[int]*gtk.CheckButton{}
+				audioTrackActivators = map[int]*gtk.CheckButton{}
+				videoTrackActivators = map[int]*gtk.CheckButton{}
+
+				var audioGroup, videoGroup *gtk.CheckButton
+				for _, track := range tracks {
+					row := adw.NewActionRow()
+					row.SetTitle(getTrackTitle(track))
+					row.SetSubtitle(getTrackDescription(track))
+
+					activator := gtk.NewCheckButton()
+
+					id := track.ID
+					switch track.Type {
+					case player.TrackTypeAudio:
+						if audioGroup != nil {
+							activator.SetGroup(audioGroup)
+						} else {
+							audioGroup = activator
+						}
+
+						activator.ConnectActivate(func() {
+							if err := playerControls.SetAudioTrack(id); err != nil {
+								openErrorDialog(ctx, window, err)
+							}
+						})
+
+						audioTrackActivators[id] = activator
+						audioTracksList.Append(row)
+						audioTrackRows = append(audioTrackRows, row)
+					case player.TrackTypeVideo:
+						if videoGroup != nil {
+							activator.SetGroup(videoGroup)
+						} else {
+							videoGroup = activator
+						}
+
+						activator.ConnectActivate(func() {
+							if err := playerControls.SetVideoTrack(id); err != nil {
+								openErrorDialog(ctx, window, err)
+							}
+						})
+
+						videoTrackActivators[id] = activator
+						videoTracksList.Append(row)
+						videoTrackRows = append(videoTrackRows, row)
+					case player.TrackTypeSubtitles:
+						// External subtitles are already listed as the files that we've added
+						if track.External {
+							continue
+						}
+
+						activator.SetGroup(activators[0])
+
+						activator.ConnectActivate(func() {
+							if err := playerControls.SetSubtitleTrack(id); err != nil {
+								openErrorDialog(ctx, window, err)
+
+								return
+							}
+
+							if canControl() {
+								broadcast(v1.TypeSelectSubtitles, v1.SelectSubtitles{
+									Track: playerControls.SubtitleTrack(id),
+								})
+							}
+						})
+
+						if description := getTrackDescription(track); description != "" {
+							row.SetSubtitle("Embedded subtitle · " + description)
+						} else {
+							row.SetSubtitle("Embedded subtitle")
+						}
+
+						subtitleTrackActivators[id] = activator
+						subtitlesSelectionGroup.Add(row)
+						embeddedSubtitleRows = append(embeddedSubtitleRows, row)
+					default:
+						continue
+					}
+
+					row.SetActivatable(true)
+
+					row.AddPrefix(activator)
+					row.SetActivatableWidget(activator)
+				}
+
+				tracksButton.SetSensitive(len(audioTrackRows) > 0 || len(videoTrackRows) > 0)
+				videoTracksLabel.SetVisible(len(videoTrackRows) > 1)
+				videoTracksList.SetVisible(len(videoTrackRows) > 1)
+			}
+
+			// Tracks can also be selected by the player itself, i.e. the default ones
+			for _, track := range tracks {
+				if !track.Selected {
+					continue
+				}
+
+				switch track.Type {
+				case player.TrackTypeAudio:
+					if activator, ok := audioTrackActivators[track.ID]; ok {
+						activator.SetActive(true)
+					}
+				case player.TrackTypeVideo:
+					if activator, ok := videoTrackActivators[track.ID]; ok {
+						activator.SetActive(true)
+					}
+				case player.TrackTypeSubtitles:
+					if activator, ok := subtitleTrackActivators[track.ID]; ok {
+						activator.SetActive(true)
+					}
+				}
+			}
+		}
+
+		seekerIsSeeking := false
 		seekerIsUnderPointer := false
 		total := time.Duration(0)
+		elapsed := time.Duration(0)
+
+		ctrl := gtk.NewEventControllerMotion()
+		ctrl.ConnectEnter(func(x, y float64) {
+			seekerIsUnderPointer = true
+		})
+		ctrl.ConnectLeave(func() {
+			seekerIsUnderPointer = false
+		})
+		seeker.AddController(ctrl)
+
+		seeker.ConnectChangeValue(func(scroll gtk.ScrollType, value float64) (ok bool) {
+			// The seeker is insensitive without control permissions, but the permissions can change while it is being dragged; handling the change ourselves keeps the value where it is
+			if !canControl() {
+				return true
+			}
+
+			seekerIsSeeking = true
+
+			seeker.SetValue(value)
+
+			elapsed := time.Duration(int64(value))
+
+			if err := playerControls.Seek(elapsed); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return false
+			}
+
+			broadcast(v1.TypeSeek, v1.Seek{
+				Position: elapsed.Seconds(),
+			})
+
+			remaining := total - elapsed
+
+			elapsedTrackLabel.SetLabel(formatDuration(elapsed))
+			remainingTrackLabel.SetLabel("-" + formatDuration(remaining))
+
+			var updateScalePosition func(done bool)
+			updateScalePosition = func(done bool) {
+				if seekerIsUnderPointer {
+					if done {
+						seekerIsSeeking = false
+
+						return
+					}
+
+					updateScalePosition(true)
+				} else {
+					seekerIsSeeking = false
+				}
+			}
+
+			time.AfterFunc(
+				time.Millisecond*200,
+				func() {
+					updateScalePosition(false)
+				},
+			)
+
+			return true
+		})
+
+		seekTo := func(position float64) error {
+			return playerControls.Seek(time.Duration(position * float64(time.Second)))
+		}
+
+		isHost := session.IsHost()
+		driftCorrector := party.NewDriftCorrector(
+			time.Duration(settings.Int64(syncSpeedThresholdFlag))*time.Millisecond,
+			time.Duration(settings.Int64(syncSeekThresholdFlag))*time.Millisecond,
+			syncMaxSpeedOffset,
+			syncCatchUpTime,
+		)
+		follower := controls.NewFollower(playerControls, driftCorrector)
+
+		lastPositionSync := time.Time{}
+
+		syncPosition := func() {
+			if partyClient == nil || time.Since(lastPositionSync) < partyPositionInterval {
+				return
+			}
+			lastPositionSync = time.Now()
+
+			paused := playButton.IconName() == playIcon
+
+			if isHost {
+				broadcast(v1.TypePosition, v1.Position{
+					Position: elapsed.Seconds(),
+					Paused:   paused,
+				})
+
+				return
+			}
+
+			if err := follower.Sync(elapsed, paused, partyClient.Clock().Now()); err != nil {
+				log.Warn().
+					Err(err).
+					Msg("Could not change speed to correct drift")
+			}
+		}
+
+		var updatePendingPlayback func()
+		bufferState := v1.BufferState{}
+		bufferStateSent := false
+		buffering := false
+
+		syncBufferState := func(pausedForCache bool, cache time.Duration) {
+			buffering = pausedForCache
+
+			newBufferState := v1.BufferState{
+				Cache: cache.Seconds(),
+			}
+
+			if progress, ok := getDownloadProgress(selectedTorrentMedia); ok {
+				newBufferState.Total = progress.total
+				newBufferState.Completed = progress.completed
+			}
+
+			newBufferState.Ready = total != 0 && !pausedForCache && (cache >= syncReadyCache || elapsed+cache >= total || (newBufferState.Total > 0 && newBufferState.Completed >= newBufferState.Total))
+
+			// We have to wait for ourselves too before starting playback for the party
+			barrier.Set(partyMemberID, newBufferState.Ready)
+
+			if bufferStateSent && newBufferState.Ready == bufferState.Ready {
+				bufferState = newBufferState
+
+				return
+			}
+
+			bufferState = newBufferState
+			bufferStateSent = true
+
+			log.Debug().
+				Bool("ready", bufferState.Ready).
+				Float64("cache", bufferState.Cache).
+				Msg("Buffer state changed")
+
+			broadcast(v1.TypeBufferState, bufferState)
+
+			if updatePendingPlayback != nil {
+				updatePendingPlayback()
+			}
+		}
+
+		subtitleDelay := 0.0
+		eofReached := false
+		var onEndOfFile func()
+
+		var pendingSnapshotLock sync.Mutex
+		var pendingSnapshot *v1.Snapshot
+		pendingSnapshotAt := time.Time{}
+		applySnapshot := func() {
+			pendingSnapshotLock.Lock()
+			snapshot, at := pendingSnapshot, pendingSnapshotAt
+			pendingSnapshot = nil
+			pendingSnapshotLock.Unlock()
+
+			if snapshot == nil {
+				return
+			}
+
+			// Account for the time that has passed since the host sent the snapshot
+			since := time.Duration(0)
+			if c := partyClient; c != nil {
+				since = c.Clock().Now().Sub(at)
+			}
+
+			if err := playerControls.ApplySnapshot(*snapshot, since); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			if snapshot.SubtitleTrack != nil {
+				if activator, ok := subtitleTrackActivators[playerControls.ActiveSubtitleTrack()]; ok {
+					activator.SetActive(true)
+				}
+			} else if activator, ok := subtitleActivators[snapshot.Subtitles]; ok {
+				activator.SetActive(true)
+			}
+
+			if snapshot.Paused {
+				playButton.SetIconName(playIcon)
+			} else {
+				playButton.SetIconName(pauseIcon)
+			}
+		}
+
+		preparingClosed := false
+		onPlayerStateChange := func(previous, current player.State) {
+			total = current.Duration.Truncate(time.Second)
+
+			if total != 0 && !preparingClosed {
+				preparingWindow.Close()
+
+				preparingClosed = true
+			}
+
+			if total != 0 {
+				applySnapshot()
+			}
+
+			elapsed = current.Position
+
+			syncPosition()
+
+			syncBufferState(current.Buffering, current.CacheTime-elapsed)
+
+			subtitleDelay = current.SubtitleDelay.Seconds()
+
+			// The delay can also be changed by the party host
+			if current.SubtitleDelay != previous.SubtitleDelay && math.Abs(subtitleDelayInput.Value()-subtitleDelay) >= 0.05 {
+				subtitleDelayInput.SetValue(subtitleDelay)
+			}
+
+			if !reflect.DeepEqual(current.Tracks, previous.Tracks) {
+				refreshTracks(current.Tracks)
+			}
+
+			// The player keeps the last frame open, so we have to advance to the next item ourselves
+			if current.EOFReached && !eofReached && onEndOfFile != nil {
+				onEndOfFile()
+			}
+			eofReached = current.EOFReached
+
+			// Pauses can also be triggered from within the player, i.e. with its keybindings
+			if current.Paused != previous.Paused {
+				if current.Paused {
+					playButton.SetIconName(playIcon)
+				} else {
+					playButton.SetIconName(pauseIcon)
+				}
+			}
+
+			if current.Volume != previous.Volume && current.Volume != volumeButton.Value() {
+				volumeButton.SetValue(current.Volume)
+			}
+
+			if !seekerIsSeeking {
+				seeker.
+					SetRange(0, float64(total.Nanoseconds()))
+				seeker.
+					SetValue(float64(elapsed.Nanoseconds()))
+
+				remaining := total - elapsed
+
+				log.Debug().
+					Float64("total", total.Seconds()).
+					Float64("elapsed", elapsed.Seconds()).
+					Float64("remaining", remaining.Seconds()).
+					Msg("Updating scale")
+
+				elapsedTrackLabel.SetLabel(formatDuration(elapsed))
+				remainingTrackLabel.SetLabel("-" + formatDuration(remaining))
+			}
+		}
+
+		// State changes arrive on the player's goroutine; handling them on the main loop keeps them from racing with the party messages
+		playerControls.Subscribe(func(previous, current player.State) {
+			glib.IdleAdd(func() {
+				onPlayerStateChange(previous, current)
+			})
+		})
+
+		if err := mediaPlayer.Launch(streamURL, apiUsername, apiPassword); err != nil {
+			openErrorDialog(ctx, window, err)
+
+			return
+		}
+
+		if err := playerControls.SetVolume(1); err != nil {
+			openErrorDialog(ctx, window, err)
+
+			return
+		}
+
+		subtitleStyles := controls.NewSubtitleStyles()
+		if err := subtitleStyles.Load(settings.String(subtitleStylesFlag)); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not load subtitle styles, resetting them")
+		}
+
+		subtitleDelayInput.SetAdjustment(gtk.NewAdjustment(0, -600, 600, 0.1, 1, 0))
+		subtitleScaleInput.SetAdjustment(gtk.NewAdjustment(1, 0.1, 5, 0.1, 1, 0))
+		subtitlePositionInput.SetAdjustment(gtk.NewAdjustment(100, 0, 150, 1, 10, 0))
+
+		subtitleStyleIsSyncing := false
+		syncSubtitleStyleInputs := func(style controls.SubtitleStyle) {
+			subtitleStyleIsSyncing = true
+			defer func() {
+				subtitleStyleIsSyncing = false
+			}()
+
+			subtitleDelayInput.SetValue(style.Delay)
+			subtitleScaleInput.SetValue(style.Scale)
+			subtitlePositionInput.SetValue(float64(style.Position))
+			subtitleFontInput.SetText(style.Font)
+		}
+
+		applySubtitleStyle := func() {
+			if subtitleStyleIsSyncing {
+				return
+			}
+
+			style := controls.SubtitleStyle{
+				Delay:    subtitleDelayInput.Value(),
+				Scale:    subtitleScaleInput.Value(),
+				Position: int(subtitlePositionInput.Value()),
+				Font:     strings.TrimSpace(subtitleFontInput.Text()),
+			}
+
+			if err := playerControls.SetSubtitleStyle(style); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			subtitleStyles.Set(magnetLink, selectedTorrentMedia, style)
+
+			raw, err := subtitleStyles.Save()
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Msg("Could not save subtitle styles")
+
+				return
+			}
+
+			settings.SetString(subtitleStylesFlag, raw)
+		}
+
+		// Reuse the style from the last time this media was played
+		subtitleStyle := subtitleStyles.Get(magnetLink, selectedTorrentMedia)
+		syncSubtitleStyleInputs(subtitleStyle)
+		if err := playerControls.SetSubtitleStyle(subtitleStyle); err != nil {
+			openErrorDialog(ctx, window, err)
+
+			return
+		}
+
+		subtitleDelayInput.ConnectValueChanged(applySubtitleStyle)
+		subtitleScaleInput.ConnectValueChanged(applySubtitleStyle)
+		subtitlePositionInput.ConnectValueChanged(applySubtitleStyle)
+		subtitleFontInput.ConnectChanged(applySubtitleStyle)
+
+		subtitleStyleResetButton.ConnectClicked(func() {
+			syncSubtitleStyleInputs(controls.DefaultSubtitleStyle())
+
+			applySubtitleStyle()
+		})
+
+		// Show the subtitles in the preferred language if one of the files matches the media well enough; guests follow the host's choice instead
+		if isHost {
+			candidates := []subtitle.Candidate{}
+			for _, file := range subtitles {
+				if file.priority == 0 {
+					candidates = append(candidates, subtitle.Candidate{
+						Path:     file.name,
+						Language: file.language,
+						Score:    file.score,
+					})
+				}
+			}
+
+			if candidate, ok := subtitle.Best(candidates, getPreferredSubtitleLanguage(settings)); ok {
+				log.Info().
+					Str("path", candidate.Path).
+					Str("language", candidate.Language).
+					Float64("score", candidate.Score).
+					Msg("Automatically selecting subtitles")
+
+				if err := playerControls.SetSubtitles(candidate.Path); err != nil {
+					log.Warn().
+						Err(err).
+						Msg("Could not automatically select subtitles, continuing without them")
+				} else {
+					if activator, ok := subtitleActivators[candidate.Path]; ok {
+						activator.SetActive(true)
+					}
+
+					broadcast(v1.TypeSelectSubtitles, v1.SelectSubtitles{
+						Path: candidate.Path,
+					})
+				}
+			}
+		}
+
+		localClock := party.NewClock(1, time.Now)
+		getClock := func() *party.Clock {
+			if partyClient == nil {
+				return localClock
+			}
+
+			return partyClient.Clock()
+		}
+
+		scheduler := party.NewScheduler()
+		var scheduledCountdown chan struct{}
+		cancelScheduledPlayback := func() {
+			scheduler.Cancel()
+
+			if scheduledCountdown != nil {
+				close(scheduledCountdown)
+
+				scheduledCountdown = nil
+			}
+
+			countdownLabel.SetVisible(false)
+			scheduleCancelButton.SetSensitive(false)
+		}
+
+		schedulePlayback := func(newSchedule v1.Schedule) error {
+			cancelScheduledPlayback()
+
+			if newSchedule.At == 0 {
+				log.Info().Msg("Cancelled scheduled playback")
+
+				return nil
+			}
+
+			clock := getClock()
+			at := time.Unix(0, newSchedule.At)
+
+			log.Info().
+				Time("at", at).
+				Dur("in", at.Sub(clock.Now())).
+				Float64("position", newSchedule.Position).
+				Msg("Scheduling playback")
+
+			if err := playerControls.SetPaused(true); err != nil {
+				return err
+			}
+
+			playButton.SetIconName(playIcon)
+
+			if err := seekTo(newSchedule.Position); err != nil {
+				return err
+			}
+
+			scheduleCancelButton.SetSensitive(true)
+
+			scheduler.Schedule(newSchedule, clock, func() {
+				log.Info().Msg("Starting scheduled playback")
+
+				if err := playerControls.SetPaused(false); err != nil {
+					glib.IdleAdd(func() {
+						openErrorDialog(ctx, window, err)
+					})
+
+					return
+				}
+
+				glib.IdleAdd(func() {
+					playButton.SetIconName(pauseIcon)
+				})
+			})
+
+			done := make(chan struct{})
+			scheduledCountdown = done
+
+			countdownLabel.SetLabel(formatDuration(at.Sub(clock.Now())))
+			countdownLabel.SetVisible(true)
+
+			go func() {
+				t := time.NewTicker(time.Millisecond * 100)
+				defer t.Stop()
+
+				for {
+					select {
+					case <-t.C:
+						remaining := at.Sub(clock.Now())
+
+						glib.IdleAdd(func() {
+							// The countdown might have been cancelled while this update was queued
+							select {
+							case <-done:
+								return
+							default:
+							}
+
+							if remaining <= 0 {
+								countdownLabel.SetVisible(false)
+								scheduleCancelButton.SetSensitive(false)
+
+								return
+							}
+
+							countdownLabel.SetLabel(formatDuration(remaining + time.Second))
+						})
+
+						if remaining <= 0 {
+							return
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+
+			return nil
+		}
+
+		scheduleButton.SetVisible(isHost)
+
+		scheduleStartButton.ConnectClicked(func() {
+			at, err := parseScheduleTime(scheduleTimeInput.Text(), time.Now())
+			if err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			newSchedule := v1.Schedule{
+				At:       getClock().Now().Add(time.Until(at)).UnixNano(),
+				Position: elapsed.Seconds(),
+			}
+
+			if err := schedulePlayback(newSchedule); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			broadcast(v1.TypeSchedule, newSchedule)
+
+			scheduleButton.Popdown()
+		})
+
+		scheduleCancelButton.ConnectClicked(func() {
+			if err := schedulePlayback(v1.Schedule{}); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			broadcast(v1.TypeSchedule, v1.Schedule{})
+
+			scheduleButton.Popdown()
+		})
+
+		startPlayback := func() {
+			cancelScheduledPlayback()
+
+			playButton.SetIconName(pauseIcon)
+
+			if err := playerControls.SetPaused(false); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			broadcast(v1.TypePlay, v1.Play{
+				Position: elapsed.Seconds(),
+			})
+		}
+
+		pausePlayback := func() {
+			cancelScheduledPlayback()
+
+			if err := playerControls.SetPaused(true); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			playButton.SetIconName(playIcon)
+
+			broadcast(v1.TypePause, v1.Pause{
+				Position: elapsed.Seconds(),
+			})
+		}
+
+		syncControlPermissions := func() {
+			seeker.SetSensitive(canControl())
+
+			if canControl() {
+				playButton.SetTooltipText("")
+			} else {
+				playButton.SetTooltipText("Ask the host to start or pause playback")
+			}
+		}
+
+		var broadcastRoles func(newRoles v1.Roles)
+
+		vote := party.NewVote()
+		var refreshVote func()
+
+		getPartyMemberSubtitle := func(member string) string {
+			parts := []string{}
+
+			switch roles.Role(member) {
+			case v1.RoleHost:
+				parts = append(parts, "Host")
+			case v1.RoleCoHost:
+				parts = append(parts, "Co-host")
+			default:
+				parts = append(parts, "Guest")
+			}
+
+			presence, at, ok := presences.Get(member)
+			if !ok {
+				return strings.Join(parts, " · ")
+			}
+
+			switch {
+			case presence.Buffering:
+				parts = append(parts, "Buffering")
+			case presence.Paused:
+				parts = append(parts, "Paused")
+			default:
+				parts = append(parts, "Playing")
+			}
+
+			position := driftCorrector.Expected(time.Duration(presence.Position*float64(time.Second)), presence.Paused || presence.Buffering, at, getClock().Now())
+			parts = append(parts, formatDuration(position))
+
+			if member != partyMemberID {
+				if drift := position - elapsed; drift >= presenceDriftThreshold {
+					parts = append(parts, fmt.Sprintf("%vs ahead", int(drift.Seconds())))
+				} else if drift <= -presenceDriftThreshold {
+					parts = append(parts, fmt.Sprintf("%vs behind", int(-drift.Seconds())))
+				}
+			}
+
+			if presence.RTT > 0 {
+				parts = append(parts, fmt.Sprintf("%v ms", time.Duration(presence.RTT).Milliseconds()))
+			}
+
+			if presence.Total > 0 {
+				parts = append(parts, fmt.Sprintf("%v%% downloaded from %v peers", presence.Completed*100/presence.Total, presence.Peers))
+			}
+
+			return strings.Join(parts, " · ")
+		}
+
+		partyMemberRows := map[string]*adw.ActionRow{}
+		updatePartyMemberSubtitles := func() {
+			for member, row := range partyMemberRows {
+				row.SetSubtitle(getPartyMemberSubtitle(member))
+			}
+		}
+
+		refreshPartyMembers := func() {
+			for _, row := range partyMemberRows {
+				partyMembersList.Remove(row)
+			}
+			partyMemberRows = map[string]*adw.ActionRow{}
+
+			partyMembers := session.Members()
+
+			members := []string{partyMemberID}
+			for member := range partyMembers {
+				members = append(members, member)
+			}
+			sort.Slice(members, func(i, j int) bool {
+				if members[i] == invite.Host || members[j] == invite.Host {
+					return members[i] == invite.Host
+				}
+
+				return partyMembers[members[i]] < partyMembers[members[j]]
+			})
+
+			for _, member := range members {
+				row := adw.NewActionRow()
+
+				if member == partyMemberID {
+					row.SetTitle(getPartyName(settings) + " (You)")
+				} else {
+					row.SetTitle(partyMembers[member])
+				}
+
+				role := roles.Role(member)
+				row.SetSubtitle(getPartyMemberSubtitle(member))
+
+				if isHost && member != partyMemberID {
+					m := member
+
+					promoteButton := gtk.NewButtonFromIconName("non-starred-symbolic")
+					promoteButton.SetTooltipText("Make co-host")
+					if role == v1.RoleCoHost {
+						promoteButton.SetIconName("starred-symbolic")
+						promoteButton.SetTooltipText("Make guest")
+					}
+					promoteButton.AddCSSClass("flat")
+					promoteButton.SetVAlign(gtk.AlignCenter)
+					promoteButton.ConnectClicked(func() {
+						if roles.Role(m) == v1.RoleCoHost {
+							broadcastRoles(roles.Demote(m))
+
+							return
+						}
+
+						broadcastRoles(roles.Promote(m))
+					})
+
+					kickButton := gtk.NewButtonFromIconName("user-trash-symbolic")
+					kickButton.SetTooltipText("Remove from party")
+					kickButton.AddCSSClass("flat")
+					kickButton.SetVAlign(gtk.AlignCenter)
+					kickButton.ConnectClicked(func() {
+						log.Info().
+							Str("member", m).
+							Msg("Removing member from party")
+
+						broadcast(v1.TypeKick, v1.Kick{
+							Member: m,
+						})
+
+						session.Leave(m)
+
+						broadcastRoles(roles.Kick(m))
+
+						if vote.Remove(m) {
+							broadcast(v1.TypePoll, vote.Get())
+						}
+						refreshVote()
+
+						// Make sure that the removed member can't read or forge any further messages
+						if partyClient != nil {
+							if err := partyClient.Rekey(m); err != nil {
+								openErrorDialog(ctx, window, err)
+
+								return
+							}
+						}
+					})
+
+					row.AddSuffix(promoteButton)
+					row.AddSuffix(kickButton)
+				}
+
+				partyMemberRows[member] = row
+				partyMembersList.Append(row)
+			}
+		}
+
+		syncPresence := func(c *party.Client) {
+			presence := v1.Presence{
+				Position:  elapsed.Seconds(),
+				Paused:    playButton.IconName() == playIcon,
+				Buffering: buffering,
+			}
+
+			if !isHost {
+				presence.RTT = c.Clock().RTT().Nanoseconds()
+			}
+
+			if progress, ok := getDownloadProgress(selectedTorrentMedia); ok {
+				presence.Peers = progress.peers
+				presence.Total = progress.total
+				presence.Completed = progress.completed
+			}
+
+			presences.Set(partyMemberID, presence, c.Clock().Now())
+
+			broadcast(v1.TypePresence, presence)
+
+			updatePartyMemberSubtitles()
+		}
+
+		broadcastRoles = func(newRoles v1.Roles) {
+			broadcast(v1.TypeRoles, newRoles)
+
+			refreshPartyMembers()
+			syncControlPermissions()
+		}
+
+		partyButton.SetVisible(strings.TrimSpace(invite.Server) != "")
+		chatButton.SetVisible(strings.TrimSpace(invite.Server) != "")
+		reactionsButton.SetVisible(strings.TrimSpace(invite.Server) != "")
+		voteButton.SetVisible(strings.TrimSpace(invite.Server) != "")
+		queueButton.SetVisible(strings.TrimSpace(invite.Server) != "")
+
+		addChatMessage := func(name, text string, at time.Time) {
+			row := gtk.NewBox(gtk.OrientationVertical, 3)
+			row.SetMarginTop(6)
+			row.SetMarginStart(12)
+			row.SetMarginEnd(12)
+			row.SetMarginBottom(6)
+
+			header := gtk.NewBox(gtk.OrientationHorizontal, 6)
+
+			nameLabel := gtk.NewLabel(name)
+			nameLabel.AddCSSClass("heading")
+			nameLabel.SetXAlign(0)
+			nameLabel.SetHExpand(true)
+			header.Append(nameLabel)
+
+			timeLabel := gtk.NewLabel(at.Format("15:04"))
+			timeLabel.AddCSSClass("dim-label")
+			timeLabel.AddCSSClass("caption")
+			header.Append(timeLabel)
+
+			row.Append(header)
+
+			textLabel := gtk.NewLabel(text)
+			textLabel.SetXAlign(0)
+			textLabel.SetWrap(true)
+			textLabel.SetSelectable(true)
+			row.Append(textLabel)
+
+			chatMessagesList.Append(row)
+
+			adjustment := chatScrolledWindow.VAdjustment()
+			adjustment.SetValue(adjustment.Upper())
+
+			if !chatPopover.Visible() {
+				chatButton.AddCSSClass("suggested-action")
+			}
+		}
+
+		chatPopover.ConnectShow(func() {
+			chatButton.RemoveCSSClass("suggested-action")
+		})
+
+		sendChatMessage := func() {
+			text := strings.TrimSpace(chatInput.Text())
+			if text == "" {
+				return
+			}
+
+			broadcast(v1.TypeChat, v1.Chat{
+				Name: getPartyName(settings),
+				Text: text,
+			})
+
+			addChatMessage(getPartyName(settings), text, time.Now())
+
+			chatInput.SetText("")
+		}
+
+		chatInput.ConnectActivate(sendChatMessage)
+		chatSendButton.ConnectClicked(sendChatMessage)
+
+		// Each reaction gets its own overlay so that they don't replace each other
+		reactionOverlayID := 0
+		showReaction := func(name, emoji string) {
+			reactionOverlayID++
+			id := reactionOverlayID
+
+			// Stack simultaneous reactions above each other in the bottom left corner
+			slot := id % reactionSlots
+			data := fmt.Sprintf(`{\an1\pos(40,%v)\fs56}%v{\fs28} %v`, 680-slot*70, escapeASS(emoji), escapeASS(name))
+
+			if err := playerControls.SetOverlay(id, data); err != nil {
+				if !errors.Is(err, player.ErrUnsupported) {
+					log.Warn().
+						Err(err).
+						Msg("Could not show reaction")
+				}
+
+				return
+			}
+
+			time.AfterFunc(reactionDuration, func() {
+				if err := playerControls.RemoveOverlay(id); err != nil {
+					log.Warn().
+						Err(err).
+						Msg("Could not hide reaction")
+				}
+			})
+		}
+
+		reactAction := gio.NewSimpleAction(reactActionName, glib.NewVariantType("s"))
+		reactAction.ConnectActivate(func(parameter *glib.Variant) {
+			emoji := parameter.String()
+
+			log.Info().
+				Str("emoji", emoji).
+				Msg("Reacting")
+
+			reactionsPopover.Popdown()
+
+			broadcast(v1.TypeReaction, v1.Reaction{
+				Name:  getPartyName(settings),
+				Emoji: emoji,
+			})
+
+			showReaction(getPartyName(settings), emoji)
+		})
+		window.AddAction(reactAction)
+		partyRestrictBox.SetVisible(isHost)
+
+		partyRestrictSwitch.ConnectStateSet(func(state bool) (ok bool) {
+			log.Info().
+				Bool("restricted", state).
+				Msg("Changing party control restrictions")
+
+			partyRestrictSwitch.SetState(state)
+
+			broadcastRoles(roles.SetRestricted(state))
+
+			return true
+		})
+
+		requestControl := func(messageType string, payload interface{}) {
+			rawPayload, err := json.Marshal(payload)
+			if err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+
+			broadcast(v1.TypeRequest, v1.Request{
+				Type:    messageType,
+				Payload: rawPayload,
+			})
+
+			overlay.AddToast(adw.NewToast("Asked the host for permission."))
+		}
+
+		approveRequestAction := gio.NewSimpleAction(approveRequestActionName, glib.NewVariantType("s"))
+		approveRequestAction.ConnectActivate(func(parameter *glib.Variant) {
+			request, ok := session.TakeRequest(parameter.String())
+			if !ok {
+				return
+			}
+
+			log.Info().
+				Str("type", request.Type).
+				Msg("Approving party request")
+
+			switch request.Type {
+			case v1.TypePlay:
+				startPlayback()
+			case v1.TypePause:
+				pausePlayback()
+			case v1.TypeSeek:
+				var seek v1.Seek
+				if err := json.Unmarshal(request.Payload, &seek); err != nil {
+					openErrorDialog(ctx, window, err)
+
+					return
+				}
+
+				if err := seekTo(seek.Position); err != nil {
+					openErrorDialog(ctx, window, err)
+
+					return
+				}
+
+				broadcast(v1.TypeSeek, seek)
+			}
+		})
+		window.AddAction(approveRequestAction)
+
+		admitMemberAction := gio.NewSimpleAction(admitMemberActionName, glib.NewVariantType("s"))
+		admitMemberAction.ConnectActivate(func(parameter *glib.Variant) {
+			if partyClient == nil {
+				return
+			}
+
+			log.Info().
+				Str("member", parameter.String()).
+				Msg("Admitting member to party")
+
+			if err := partyClient.Admit(parameter.String()); err != nil {
+				openErrorDialog(ctx, window, err)
+
+				return
+			}
+		})
+		window.AddAction(admitMemberAction)
+
+		var pendingPlayback *time.Timer
+		cancelPendingPlayback := func() {
+			if pendingPlayback != nil {
+				pendingPlayback.Stop()
+			}
+			pendingPlayback = nil
+
+			bufferingRevealer.SetRevealChild(false)
+		}
+
+		updatePendingPlayback = func() {
+			if pendingPlayback == nil {
+				return
+			}
+
+			waiting := barrier.Waiting()
+			if len(waiting) == 0 {
+				log.Info().Msg("Party has buffered, starting playback")
+
+				cancelPendingPlayback()
+				startPlayback()
+
+				return
+			}
+
+			names := []string{}
+			for _, member := range waiting {
+				if member == partyMemberID {
+					names = append(names, "you")
+				} else if name, ok := session.Name(member); ok {
+					names = append(names, name)
+				} else {
+					names = append(names, member)
+				}
+			}
+
+			bufferingLabel.SetLabel(fmt.Sprintf("Waiting for %v to buffer ...", strings.Join(names, ", ")))
+			bufferingRevealer.SetRevealChild(true)
+		}
+
+		bufferingSkipButton.ConnectClicked(func() {
+			cancelPendingPlayback()
+			startPlayback()
+		})
+		switchMedia := func(magnet, path string) {
+			log.Info().
+				Str("magnet", magnet).
+				Str("path", path).
+				Msg("Switching media for party")
+
+			pendingMediaSwitch = magnet + "\x00" + path
+			key := pendingMediaSwitch
+
+			// Getting the info can take a while if the magnet link is new to the gateway
+			go func() {
+				info, err := manager.GetInfo(magnet)
+
+				glib.IdleAdd(func() {
+					// The party has moved on or we've left it in the meantime
+					if pendingMediaSwitch != key {
+						return
+					}
+					pendingMediaSwitch = ""
+
+					if err != nil {
+						openErrorDialog(ctx, window, err)
+
+						return
+					}
+
+					torrentMedia := []media{}
+					for _, file := range info.Files {
+						torrentMedia = append(torrentMedia, media{
+							name: file.Path,
+							size: int(file.Length),
+						})
+					}
+
+					window.Close()
+
+					invite.Magnet = magnet
+					invite.Path = path
+
+					if err := openControlsWindow(ctx, app, info.Name, getSubtitles(torrentMedia, path, getPreferredSubtitleLanguage(settings)), info.Description, manager, apiAddr, apiUsername, apiPassword, invite, partyMemberID, queue, keyring, settings, gateway, cancel, tmpDir); err != nil {
+						openErrorDialog(ctx, window, err)
+					}
+				})
+			}()
+		}
+
+		playVoteResult := func(candidate string) {
+			log.Info().
+				Str("path", candidate).
+				Msg("Playing result of vote")
+
+			broadcast(v1.TypePoll, vote.Close())
+			refreshVote()
+
+			if candidate == selectedTorrentMedia {
+				return
+			}
+
+			broadcast(v1.TypeSelectMedia, v1.SelectMedia{
+				Magnet: magnetLink,
+				Path:   candidate,
+			})
+
+			switchMedia(magnetLink, candidate)
+		}
+
+		castBallot := func(candidate string) {
+			ballot := v1.Ballot{
+				Poll:      vote.Get().ID,
+				Candidate: candidate,
+			}
+
+			if !vote.Cast(partyMemberID, ballot) {
+				return
+			}
+
+			log.Info().
+				Str("path", candidate).
+				Msg("Voting")
+
+			// The host counts the ballots, so it sends the updated tallies instead
+			if isHost {
+				broadcast(v1.TypePoll, vote.Get())
+			} else {
+				broadcast(v1.TypeBallot, ballot)
+			}
+
+			refreshVote()
+		}
+
+		renderedPoll := ""
+		voteRows := map[string]*adw.ActionRow{}
+		voteActivators := map[string]*gtk.CheckButton{}
+		refreshVote = func() {
+			poll := vote.Get()
+
+			voteStartButton.SetVisible(isHost && poll.Closed)
+			voteEndButton.SetVisible(isHost && !poll.Closed)
+			voteScrolledWindow.SetVisible(!poll.Closed)
+
+			if poll.Closed {
+				voteStatusLabel.SetLabel("No vote in progress.")
+				voteButton.RemoveCSSClass("suggested-action")
+
+				return
+			}
+
+			voteStatusLabel.SetLabel(fmt.Sprintf("Vote on what to watch next. %v of %v members have voted.", len(poll.Ballots), len(session.Members())+1))
+
+			// Only rebuild the rows for new polls so that we don't replace the check button that is being activated
+			if renderedPoll != poll.ID {
+				renderedPoll = poll.ID
+
+				for _, row := range voteRows {
+					voteList.Remove(row)
+				}
+				voteRows = map[string]*adw.ActionRow{}
+				voteActivators = map[string]*gtk.CheckButton{}
+
+				var group *gtk.CheckButton
+				for _, candidate := range poll.Candidates {
+					row := adw.NewActionRow()
+
+					activator := gtk.NewCheckButton()
+					if group != nil {
+						activator.SetGroup(group)
+					} else {
+						group = activator
+					}
+
+					c := candidate
+					activator.ConnectActivate(func() {
+						castBallot(c)
+					})
+
+					row.SetTitle(getDisplayPathWithoutRoot(candidate))
+					row.SetActivatable(true)
+
+					row.AddPrefix(activator)
+					row.SetActivatableWidget(activator)
+
+					if isHost {
+						overrideButton := gtk.NewButtonFromIconName("media-playback-start-symbolic")
+						overrideButton.AddCSSClass("flat")
+						overrideButton.SetVAlign(gtk.AlignCenter)
+						overrideButton.SetTooltipText("Play now")
+
+						overrideButton.ConnectClicked(func() {
+							playVoteResult(c)
+						})
+
+						row.AddSuffix(overrideButton)
+					}
+
+					voteRows[candidate] = row
+					voteActivators[candidate] = activator
+					voteList.Append(row)
+				}
+			}
+
+			tally := vote.Tally()
+			for candidate, row := range voteRows {
+				switch tally[candidate] {
+				case 0:
+					row.SetSubtitle("No votes")
+				case 1:
+					row.SetSubtitle("1 vote")
+				default:
+					row.SetSubtitle(fmt.Sprintf("%v votes", tally[candidate]))
+				}
+			}
+
+			if activator, ok := voteActivators[poll.Ballots[partyMemberID]]; ok {
+				activator.SetActive(true)
+			}
+		}
+
+		refreshVote()
+
+		votePopover.ConnectShow(func() {
+			voteButton.RemoveCSSClass("suggested-action")
+		})
+
+		voteStartButton.ConnectClicked(func() {
+			voteStartButton.SetSensitive(false)
+
+			go func() {
+				info, err := manager.GetInfo(magnetLink)
+
+				glib.IdleAdd(func() {
+					voteStartButton.SetSensitive(true)
+
+					if err != nil {
+						openErrorDialog(ctx, window, err)
+
+						return
+					}
+
+					// Subtitles, samples, covers etc. aren't worth voting on
+					candidates := []string{}
+					for _, file := range info.Files {
+						if isMedia(file.Path) {
+							candidates = append(candidates, file.Path)
+						}
+					}
+
+					log.Info().
+						Int("candidates", len(candidates)).
+						Msg("Starting vote")
+
+					broadcast(v1.TypePoll, vote.Open(randSeq(20), candidates))
+					refreshVote()
+				})
+			}()
+		})
+
+		voteEndButton.ConnectClicked(func() {
+			winner, ok := vote.Winner()
+			if !ok {
+				overlay.AddToast(adw.NewToast("Nobody has voted yet."))
+
+				return
+			}
+
+			playVoteResult(winner)
+		})
+
+		playNext := func() {
+			item, ok := queue.Next()
+			if !ok {
+				return
+			}
+
+			log.Info().
+				Str("magnet", item.Magnet).
+				Str("path", item.Path).
+				Msg("Playing next item in queue")
+
+			broadcast(v1.TypeQueue, queue.Get())
+			broadcast(v1.TypeSelectMedia, v1.SelectMedia{
+				Magnet: item.Magnet,
+				Path:   item.Path,
+			})
+
+			switchMedia(item.Magnet, item.Path)
+		}
+
+		// Only the host advances the queue so that members don't skip items
+		onEndOfFile = func() {
+			if !isHost {
+				return
+			}
+
+			playNext()
+		}
+
+		queueRows := []*adw.ActionRow{}
+		var refreshQueue func()
+		refreshQueue = func() {
+			for _, row := range queueRows {
+				queueList.Remove(row)
+			}
+			queueRows = []*adw.ActionRow{}
+
+			items := queue.Get().Items
+
+			queueEmptyLabel.SetVisible(len(items) == 0)
+			queueScrolledWindow.SetVisible(len(items) > 0)
+
+			for _, item := range items {
+				row := adw.NewActionRow()
+
+				row.SetTitle(getDisplayPathWithoutRoot(item.Path))
+				if item.SuggestedBy == "" {
+					row.SetSubtitle(item.Title)
+				} else {
+					row.SetSubtitle(fmt.Sprintf("%v · Suggested by %v", item.Title, item.SuggestedBy))
+				}
+
+				if isHost {
+					id := item.ID
+
+					upButton := gtk.NewButtonFromIconName("go-up-symbolic")
+					upButton.AddCSSClass("flat")
+					upButton.SetVAlign(gtk.AlignCenter)
+					upButton.SetTooltipText("Play earlier")
+					upButton.ConnectClicked(func() {
+						broadcast(v1.TypeQueue, queue.Move(id, -1))
+						refreshQueue()
+					})
+
+					downButton := gtk.NewButtonFromIconName("go-down-symbolic")
+					downButton.AddCSSClass("flat")
+					downButton.SetVAlign(gtk.AlignCenter)
+					downButton.SetTooltipText("Play later")
+					downButton.ConnectClicked(func() {
+						broadcast(v1.TypeQueue, queue.Move(id, 1))
+						refreshQueue()
+					})
+
+					removeButton := gtk.NewButtonFromIconName("user-trash-symbolic")
+					removeButton.AddCSSClass("flat")
+					removeButton.SetVAlign(gtk.AlignCenter)
+					removeButton.SetTooltipText("Remove from queue")
+					removeButton.ConnectClicked(func() {
+						broadcast(v1.TypeQueue, queue.Remove(id))
+						refreshQueue()
+					})
+
+					row.AddSuffix(upButton)
+					row.AddSuffix(downButton)
+					row.AddSuffix(removeButton)
+				}
+
+				queueRows = append(queueRows, row)
+				queueList.Append(row)
+			}
+		}
+
+		refreshQueue()
+
+		addToQueue := func(magnet, path, title string) {
+			if !isHost {
+				log.Info().
+					Str("magnet", magnet).
+					Str("path", path).
+					Msg("Suggesting item for queue")
+
+				broadcast(v1.TypeSuggestion, v1.Suggestion{
+					Magnet: magnet,
+					Path:   path,
+					Title:  title,
+				})
+
+				overlay.AddToast(adw.NewToast("Suggested to the host."))
+
+				return
+			}
+
+			log.Info().
+				Str("magnet", magnet).
+				Str("path", path).
+				Msg("Adding item to queue")
+
+			broadcast(v1.TypeQueue, queue.Add(v1.QueueItem{
+				ID:     randSeq(20),
+				Magnet: magnet,
+				Path:   path,
+				Title:  title,
+			}))
+			refreshQueue()
+		}
+
+		addSuggestionAction := gio.NewSimpleAction(addSuggestionActionName, glib.NewVariantType("s"))
+		addSuggestionAction.ConnectActivate(func(parameter *glib.Variant) {
+			item, ok := session.TakeSuggestion(parameter.String())
+			if !ok {
+				return
+			}
+
+			log.Info().
+				Str("magnet", item.Magnet).
+				Str("path", item.Path).
+				Str("suggestedBy", item.SuggestedBy).
+				Msg("Adding suggestion to queue")
+
+			broadcast(v1.TypeQueue, queue.Add(item))
+			refreshQueue()
+		})
+		window.AddAction(addSuggestionAction)
+
+		queueFileRows := []*adw.ActionRow{}
+		browseQueueFiles := func() {
+			magnet := strings.TrimSpace(queueMagnetInput.Text())
+			if magnet == "" {
+				magnet = magnetLink
+			}
+
+			queueBrowseButton.SetSensitive(false)
+
+			go func() {
+				info, err := manager.GetInfo(magnet)
+
+				glib.IdleAdd(func() {
+					queueBrowseButton.SetSensitive(true)
+
+					if err != nil {
+						log.Warn().
+							Str("magnetLink", magnet).
+							Err(err).
+							Msg("Could not get info for magnet link")
+
+						overlay.AddToast(adw.NewToast("Could not get info for this magnet link."))
+
+						return
+					}
+
+					for _, row := range queueFileRows {
+						queueFilesList.Remove(row)
+					}
+					queueFileRows = []*adw.ActionRow{}
+
+					for _, file := range info.Files {
+						row := adw.NewActionRow()
+
+						row.SetTitle(getDisplayPathWithoutRoot(file.Path))
+						row.SetSubtitle(fmt.Sprintf("%v MB", file.Length/1000/1000))
+
+						addButton := gtk.NewButtonFromIconName("list-add-symbolic")
+						addButton.AddCSSClass("flat")
+						addButton.SetVAlign(gtk.AlignCenter)
+						if isHost {
+							addButton.SetTooltipText("Add to queue")
+						} else {
+							addButton.SetTooltipText("Suggest to host")
+						}
+
+						p := file.Path
+						addButton.ConnectClicked(func() {
+							addToQueue(magnet, p, info.Name)
+						})
+
+						row.AddSuffix(addButton)
+
+						queueFileRows = append(queueFileRows, row)
+						queueFilesList.Append(row)
+					}
+
+					queueFilesScrolledWindow.SetVisible(len(queueFileRows) > 0)
+				})
+			}()
+		}
+
+		queueMagnetInput.ConnectActivate(browseQueueFiles)
+		queueBrowseButton.ConnectClicked(browseQueueFiles)
+
+		sendSnapshot := func(to string) {
+			broadcast(v1.TypeSnapshot, v1.Snapshot{
+				To:            to,
+				Magnet:        magnetLink,
+				Path:          selectedTorrentMedia,
+				Subtitles:     playerControls.ActiveSubtitles(),
+				SubtitleTrack: playerControls.SubtitleTrack(playerControls.ActiveSubtitleTrack()),
+				SubtitleDelay: subtitleDelay,
+				Paused:        playButton.IconName() == playIcon,
+				Position:      elapsed.Seconds(),
+			})
+		}
+
+		joinedAt := time.Time{}
+		handlePartyMessage := func(c *party.Client, msg v1.Message) error {
+			// Messages that were queued before we left the party are stale
+			if partyClient != c {
+				return nil
+			}
+
+			if !session.Accept(msg) {
+				log.Debug().
+					Str("type", msg.Type).
+					Str("from", msg.From).
+					Msg("Ignoring party message that the sender isn't allowed to send")
+
+				return nil
+			}
+
+			switch msg.Type {
+			case v1.TypeJoin:
+				var join v1.Join
+				if err := json.Unmarshal(msg.Payload, &join); err != nil {
+					return err
+				}
+
+				// Members that reconnect announce themselves again
+				if session.Join(msg.From, join.Name) && msg.Timestamp > joinedAt.UnixNano() {
+					overlay.AddToast(adw.NewToast(fmt.Sprintf("%v joined the party.", join.Name)))
+				}
+
+				if isHost {
+					broadcast(v1.TypeRoles, roles.Get())
+
+					if schedule := scheduler.Get(); schedule.At != 0 {
+						broadcast(v1.TypeSchedule, schedule)
+					}
+
+					if poll := vote.Get(); !poll.Closed {
+						broadcast(v1.TypePoll, poll)
+					}
+
+					broadcast(v1.TypeQueue, queue.Get())
+
+					// Bring members that join mid-movie or reconnect up to speed
+					if msg.Timestamp > joinedAt.UnixNano() {
+						sendSnapshot(msg.From)
+					}
+				}
 
-		ctrl := gtk.NewEventControllerMotion()
-		ctrl.ConnectEnter(func(x, y float64) {
-			seekerIsUnderPointer = true
-		})
-		ctrl.ConnectLeave(func() {
-			seekerIsUnderPointer = false
-		})
-		seeker.AddController(ctrl)
+				refreshPartyMembers()
 
-		seeker.ConnectChangeValue(func(scroll gtk.ScrollType, value float64) (ok bool) {
-			seekerIsSeeking = true
+				// Let the new member know whether we're ready
+				if bufferStateSent {
+					broadcast(v1.TypeBufferState, bufferState)
+				}
 
-			seeker.SetValue(value)
+				updatePendingPlayback()
+			case v1.TypeLeave:
+				name, ok := session.Leave(msg.From)
+				if !ok {
+					return nil
+				}
 
-			elapsed := time.Duration(int64(value))
+				overlay.AddToast(adw.NewToast(fmt.Sprintf("%v left the party.", name)))
 
-			if err := encoder.Encode(mpvCommand{[]interface{}{"seek", int64(elapsed.Seconds()), "absolute"}}); err != nil {
-				openErrorDialog(ctx, window, err)
+				refreshPartyMembers()
 
-				return false
-			}
+				if isHost && vote.Remove(msg.From) {
+					broadcast(v1.TypePoll, vote.Get())
+				}
+				refreshVote()
 
-			log.Info().
-				Dur("duration", elapsed).
-				Msg("Seeking")
+				updatePendingPlayback()
+			case v1.TypePlay:
+				var play v1.Play
+				if err := json.Unmarshal(msg.Payload, &play); err != nil {
+					return err
+				}
 
-			remaining := total - elapsed
+				log.Info().
+					Float64("position", play.Position).
+					Msg("Starting playback for party")
 
-			elapsedTrackLabel.SetLabel(formatDuration(elapsed))
-			remainingTrackLabel.SetLabel("-" + formatDuration(remaining))
+				cancelScheduledPlayback()
 
-			var updateScalePosition func(done bool)
-			updateScalePosition = func(done bool) {
-				if seekerIsUnderPointer {
-					if done {
-						seekerIsSeeking = false
+				if err := seekTo(play.Position); err != nil {
+					return err
+				}
 
-						return
+				if err := playerControls.SetPaused(false); err != nil {
+					return err
+				}
+
+				playButton.SetIconName(pauseIcon)
+			case v1.TypePause:
+				var pause v1.Pause
+				if err := json.Unmarshal(msg.Payload, &pause); err != nil {
+					return err
+				}
+
+				log.Info().
+					Float64("position", pause.Position).
+					Msg("Pausing playback for party")
+
+				cancelScheduledPlayback()
+
+				if err := playerControls.SetPaused(true); err != nil {
+					return err
+				}
+
+				if err := seekTo(pause.Position); err != nil {
+					return err
+				}
+
+				playButton.SetIconName(playIcon)
+			case v1.TypeSeek:
+				var seek v1.Seek
+				if err := json.Unmarshal(msg.Payload, &seek); err != nil {
+					return err
+				}
+
+				log.Info().
+					Float64("position", seek.Position).
+					Msg("Seeking for party")
+
+				return seekTo(seek.Position)
+			case v1.TypePresence:
+				var presence v1.Presence
+				if err := json.Unmarshal(msg.Payload, &presence); err != nil {
+					return err
+				}
+
+				presences.Set(msg.From, presence, time.Unix(0, msg.Timestamp))
+
+				updatePartyMemberSubtitles()
+			case v1.TypeChat:
+				var chat v1.Chat
+				if err := json.Unmarshal(msg.Payload, &chat); err != nil {
+					return err
+				}
+
+				if !session.SeeChat(msg) {
+					return nil
+				}
+
+				addChatMessage(chat.Name, chat.Text, time.Unix(0, msg.Timestamp).Add(-c.Clock().Offset()))
+
+				// Don't flood the player with the history that is replayed when joining
+				if settings.Boolean(partyChatOSDFlag) && msg.Timestamp > joinedAt.UnixNano() {
+					if err := playerControls.ShowText(fmt.Sprintf("%v: %v", chat.Name, chat.Text), time.Second*5); err != nil && !errors.Is(err, player.ErrUnsupported) {
+						return err
 					}
+				}
+			case v1.TypeQueue:
+				var newQueue v1.Queue
+				if err := json.Unmarshal(msg.Payload, &newQueue); err != nil {
+					return err
+				}
 
-					updateScalePosition(true)
-				} else {
-					seekerIsSeeking = false
+				queue.Set(newQueue)
+				refreshQueue()
+			case v1.TypeSuggestion:
+				var suggestion v1.Suggestion
+				if err := json.Unmarshal(msg.Payload, &suggestion); err != nil {
+					return err
 				}
-			}
 
-			time.AfterFunc(
-				time.Millisecond*200,
-				func() {
-					updateScalePosition(false)
-				},
-			)
+				item := session.AddSuggestion(msg, suggestion)
 
-			return true
-		})
+				toast := adw.NewToast(fmt.Sprintf("%v suggested %v.", item.SuggestedBy, getDisplayPathWithoutRoot(suggestion.Path)))
+				toast.SetButtonLabel("Add")
+				toast.SetActionName("win." + addSuggestionActionName)
+				toast.SetActionTargetValue(glib.NewVariantString(item.ID))
 
-		preparingClosed := false
-		done := make(chan struct{})
-		go func() {
-			t := time.NewTicker(time.Millisecond * 100)
+				overlay.AddToast(toast)
+			case v1.TypePoll:
+				var poll v1.Poll
+				if err := json.Unmarshal(msg.Payload, &poll); err != nil {
+					return err
+				}
 
-			updateSeeker := func() {
-				encoder := json.NewEncoder(sock)
-				decoder := json.NewDecoder(sock)
+				if previous := vote.Get(); !poll.Closed && (previous.Closed || previous.ID != poll.ID) {
+					overlay.AddToast(adw.NewToast("The host started a vote on what to watch next."))
 
-				if err := encoder.Encode(mpvCommand{[]interface{}{"get_property", "duration"}}); err != nil {
-					openErrorDialog(ctx, window, err)
+					if !votePopover.Visible() {
+						voteButton.AddCSSClass("suggested-action")
+					}
+				}
 
-					return
+				vote.Set(poll)
+				refreshVote()
+			case v1.TypeBallot:
+				var ballot v1.Ballot
+				if err := json.Unmarshal(msg.Payload, &ballot); err != nil {
+					return err
 				}
 
-				var durationResponse mpvFloat64Response
-				if err := decoder.Decode(&durationResponse); err != nil {
-					log.Error().
-						Err(err).
-						Msg("Could not parse JSON from socket")
+				if !vote.Cast(msg.From, ballot) {
+					return nil
+				}
 
-					return
+				broadcast(v1.TypePoll, vote.Get())
+				refreshVote()
+			case v1.TypeReaction:
+				var reaction v1.Reaction
+				if err := json.Unmarshal(msg.Payload, &reaction); err != nil {
+					return err
 				}
 
-				total, err = time.ParseDuration(fmt.Sprintf("%vs", int64(durationResponse.Data)))
-				if err != nil {
-					openErrorDialog(ctx, window, err)
+				showReaction(reaction.Name, reaction.Emoji)
+			case v1.TypeSchedule:
+				var newSchedule v1.Schedule
+				if err := json.Unmarshal(msg.Payload, &newSchedule); err != nil {
+					return err
+				}
 
-					return
+				if err := schedulePlayback(newSchedule); err != nil {
+					return err
 				}
 
-				if total != 0 && !preparingClosed {
-					preparingWindow.Close()
+				if newSchedule.At != 0 {
+					overlay.AddToast(adw.NewToast(fmt.Sprintf("Playback starts at %v.", time.Unix(0, newSchedule.At).Add(-c.Clock().Offset()).Format("15:04:05"))))
+				}
+			case v1.TypeRoles:
+				var newRoles v1.Roles
+				if err := json.Unmarshal(msg.Payload, &newRoles); err != nil {
+					return err
+				}
 
-					preparingClosed = true
+				roles.Set(newRoles)
+
+				refreshPartyMembers()
+				syncControlPermissions()
+			case v1.TypeKick:
+				var kick v1.Kick
+				if err := json.Unmarshal(msg.Payload, &kick); err != nil {
+					return err
 				}
 
-				if err := encoder.Encode(mpvCommand{[]interface{}{"get_property", "time-pos"}}); err != nil {
-					openErrorDialog(ctx, window, err)
+				if kick.Member == partyMemberID {
+					log.Info().Msg("Removed from party by host")
 
-					return
+					closeParty()
+
+					partyButton.SetVisible(false)
+					chatButton.SetVisible(false)
+					reactionsButton.SetVisible(false)
+					voteButton.SetVisible(false)
+					queueButton.SetVisible(false)
+					syncControlPermissions()
+
+					overlay.AddToast(adw.NewToast("The host removed you from the party."))
+
+					return nil
 				}
 
-				var elapsedResponse mpvFloat64Response
-				if err := decoder.Decode(&elapsedResponse); err != nil {
-					log.Error().Err(err).Msg("Could not parse JSON from socket")
+				session.Leave(kick.Member)
 
-					return
+				refreshPartyMembers()
+				refreshVote()
+			case v1.TypeRequest:
+				var request v1.Request
+				if err := json.Unmarshal(msg.Payload, &request); err != nil {
+					return err
 				}
 
-				elapsed, err := time.ParseDuration(fmt.Sprintf("%vs", int64(elapsedResponse.Data)))
-				if err != nil {
-					openErrorDialog(ctx, window, err)
+				name, _ := session.Name(msg.From)
+
+				var title string
+				switch request.Type {
+				case v1.TypePlay:
+					title = fmt.Sprintf("%v wants to start playback.", name)
+				case v1.TypePause:
+					title = fmt.Sprintf("%v wants to pause playback.", name)
+				case v1.TypeSeek:
+					title = fmt.Sprintf("%v wants to seek.", name)
+				default:
+					return nil
+				}
 
-					return
+				requestID := session.AddRequest(msg, request)
+
+				toast := adw.NewToast(title)
+				toast.SetButtonLabel("Allow")
+				toast.SetActionName("win." + approveRequestActionName)
+				toast.SetActionTargetValue(glib.NewVariantString(requestID))
+
+				overlay.AddToast(toast)
+			case v1.TypeBufferState:
+				var memberBufferState v1.BufferState
+				if err := json.Unmarshal(msg.Payload, &memberBufferState); err != nil {
+					return err
 				}
 
-				if !seekerIsSeeking {
-					seeker.
-						SetRange(0, float64(total.Nanoseconds()))
-					seeker.
-						SetValue(float64(elapsed.Nanoseconds()))
+				barrier.Set(msg.From, memberBufferState.Ready)
 
-					remaining := total - elapsed
+				updatePendingPlayback()
+			case v1.TypePosition:
+				var position v1.Position
+				if err := json.Unmarshal(msg.Payload, &position); err != nil {
+					return err
+				}
 
-					log.Debug().
-						Float64("total", total.Seconds()).
-						Float64("elapsed", elapsed.Seconds()).
-						Float64("remaining", remaining.Seconds()).
-						Msg("Updating scale")
+				follower.SetReference(time.Duration(position.Position*float64(time.Second)), position.Paused, time.Unix(0, msg.Timestamp))
+			case v1.TypeSelectSubtitles:
+				var selectSubtitles v1.SelectSubtitles
+				if err := json.Unmarshal(msg.Payload, &selectSubtitles); err != nil {
+					return err
+				}
 
-					elapsedTrackLabel.SetLabel(formatDuration(elapsed))
-					remainingTrackLabel.SetLabel("-" + formatDuration(remaining))
+				if err := playerControls.SelectSubtitles(selectSubtitles); err != nil {
+					return err
 				}
-			}
 
-			for {
-				select {
-				case <-t.C:
-					updateSeeker()
-				case <-done:
-					return
+				if selectSubtitles.Track != nil {
+					if activator, ok := subtitleTrackActivators[playerControls.ActiveSubtitleTrack()]; ok {
+						activator.SetActive(true)
+					}
+				} else if activator, ok := subtitleActivators[selectSubtitles.Path]; ok {
+					activator.SetActive(true)
+				}
+			case v1.TypeSelectMedia:
+				var selectMedia v1.SelectMedia
+				if err := json.Unmarshal(msg.Payload, &selectMedia); err != nil {
+					return err
+				}
+
+				if selectMedia.Magnet == magnetLink && selectMedia.Path == selectedTorrentMedia {
+					return nil
+				}
+
+				switchMedia(selectMedia.Magnet, selectMedia.Path)
+			case v1.TypeSnapshot:
+				var snapshot v1.Snapshot
+				if err := json.Unmarshal(msg.Payload, &snapshot); err != nil {
+					return err
+				}
+
+				if snapshot.To != "" && snapshot.To != partyMemberID {
+					return nil
 				}
+
+				// The party has moved on since the invite was created; the host sends a new snapshot once we've joined with the new media
+				if snapshot.Magnet != magnetLink || snapshot.Path != selectedTorrentMedia {
+					switchMedia(snapshot.Magnet, snapshot.Path)
+
+					return nil
+				}
+
+				// The snapshot is applied as soon as the media has loaded
+				pendingSnapshotLock.Lock()
+				pendingSnapshot = &snapshot
+				pendingSnapshotAt = time.Unix(0, msg.Timestamp)
+				pendingSnapshotLock.Unlock()
 			}
-		}()
 
-		volumeButton.ConnectValueChanged(func(value float64) {
-			log.Info().
-				Float64("value", value).
-				Msg("Setting volume")
+			return nil
+		}
 
-			if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "volume", value * 100}}); err != nil {
+		volumeButton.ConnectValueChanged(func(value float64) {
+			if err := playerControls.SetVolume(value); err != nil {
 				openErrorDialog(ctx, window, err)
 
 				return
@@ -1046,12 +3181,8 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 			filePicker.SetModal(true)
 			filePicker.ConnectResponse(func(responseId int) {
 				if responseId == int(gtk.ResponseAccept) {
-					log.Info().
-						Str("path", filePicker.File().Path()).
-						Msg("Setting subtitles")
-
 					m := filePicker.File().Path()
-					if err := encoder.Encode(mpvCommand{[]interface{}{"change-list", "sub-files", "set", m}}); err != nil {
+					if err := playerControls.SetLocalSubtitles(m); err != nil {
 						openErrorDialog(ctx, window, err)
 
 						return
@@ -1066,7 +3197,7 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 
 					activator.SetActive(true)
 					activator.ConnectActivate(func() {
-						if err := encoder.Encode(mpvCommand{[]interface{}{"change-list", "sub-files", "set", m}}); err != nil {
+						if err := playerControls.SetLocalSubtitles(m); err != nil {
 							openErrorDialog(ctx, window, err)
 
 							return
@@ -1092,9 +3223,7 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 
 		fullscreenButton.ConnectClicked(func() {
 			if fullscreenButton.Active() {
-				log.Info().Msg("Enabling fullscreen")
-
-				if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "fullscreen", true}}); err != nil {
+				if err := playerControls.SetFullscreen(true); err != nil {
 					openErrorDialog(ctx, window, err)
 
 					return
@@ -1103,9 +3232,7 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 				return
 			}
 
-			log.Info().Msg("Disabling fullscreen")
-
-			if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "fullscreen", false}}); err != nil {
+			if err := playerControls.SetFullscreen(false); err != nil {
 				openErrorDialog(ctx, window, err)
 
 				return
@@ -1113,41 +3240,224 @@ func openControlsWindow(ctx context.Context, app *adw.Application, torrentTitle
 		})
 
 		playButton.ConnectClicked(func() {
+			if !canControl() {
+				if playButton.IconName() == playIcon {
+					requestControl(v1.TypePlay, v1.Play{
+						Position: elapsed.Seconds(),
+					})
+
+					return
+				}
+
+				requestControl(v1.TypePause, v1.Pause{
+					Position: elapsed.Seconds(),
+				})
+
+				return
+			}
+
 			if playButton.IconName() == playIcon {
-				log.Info().Msg("Starting playback")
+				if pendingPlayback != nil {
+					log.Info().Msg("Cancelling pending playback")
 
-				playButton.SetIconName(pauseIcon)
+					cancelPendingPlayback()
 
-				if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "pause", false}}); err != nil {
-					openErrorDialog(ctx, window, err)
+					return
+				}
+
+				// Co-hosts can start playback too, so they have to wait for the party just like the host
+				if waiting := barrier.Waiting(); canControl() && partyClient != nil && len(waiting) > 0 {
+					log.Info().
+						Strs("waiting", waiting).
+						Msg("Waiting for party to buffer before starting playback")
+
+					var timer *time.Timer
+					timer = time.AfterFunc(time.Duration(settings.Int64(syncBufferTimeoutFlag))*time.Second, func() {
+						glib.IdleAdd(func() {
+							// The pending playback has been cancelled or started while the timer was firing
+							if pendingPlayback != timer {
+								return
+							}
+
+							log.Info().Msg("Timed out waiting for party to buffer, starting playback anyways")
+
+							cancelPendingPlayback()
+							startPlayback()
+						})
+					})
+					pendingPlayback = timer
+
+					updatePendingPlayback()
 
 					return
 				}
 
+				startPlayback()
+
 				return
 			}
 
-			log.Info().Msg("Pausing playback")
+			pausePlayback()
+		})
+
+		if strings.TrimSpace(invite.Server) != "" {
+			peerToPeer := settings.Boolean(partyPeerToPeerFlag)
+			stunServers := strings.Fields(settings.String(partySTUNServersFlag))
+
+			// The handlers keep a reference to this client since `partyClient` is reset when leaving the party
+			var c *party.Client
+			c = party.NewClient(
+				func() party.Transport {
+					var transport party.Transport = party.NewRelayTransport(invite.Server, ctx)
+					if peerToPeer {
+						transport = party.NewWebRTCTransport(
+							transport,
+							invite.Room,
+							partyMemberID,
+							keyring,
+							stunServers,
+							ctx,
+						)
+					}
+
+					return transport
+				},
+				invite.Room,
+				keyring,
+				partyMemberID,
+				getPartyName(settings),
+				invite.Host,
+				partyHeartbeatInterval,
+				partyHeartbeatTimeout,
+				partyClockSyncInterval,
+				partyClockSamples,
+				// Messages arrive on the party client's goroutine, so we hand them to the main loop, which owns the UI and the party state
+				func(msg v1.Message) {
+					glib.IdleAdd(func() {
+						if err := handlePartyMessage(c, msg); err != nil {
+							log.Warn().
+								Str("type", msg.Type).
+								Str("from", msg.From).
+								Err(err).
+								Msg("Could not apply party message")
+						}
+					})
+				},
+				func(id, name string) {
+					glib.IdleAdd(func() {
+						if partyClient != c {
+							return
+						}
+
+						toast := adw.NewToast(fmt.Sprintf("%v wants to join the party.", name))
+						toast.SetButtonLabel("Admit")
+						toast.SetActionName("win." + admitMemberActionName)
+						toast.SetActionTargetValue(glib.NewVariantString(id))
+						toast.SetPriority(adw.ToastPriorityHigh)
+
+						overlay.AddToast(toast)
+					})
+				},
+				func(connected bool) {
+					glib.IdleAdd(func() {
+						if !connected {
+							overlay.AddToast(adw.NewToast("Lost connection to party, reconnecting ..."))
+
+							return
+						}
+
+						overlay.AddToast(adw.NewToast("Reconnected to party."))
+
+						// Everyone else might have missed what we did while we were gone
+						if isHost {
+							broadcast(v1.TypeRoles, roles.Get())
+
+							if schedule := scheduler.Get(); schedule.At != 0 {
+								broadcast(v1.TypeSchedule, schedule)
+							}
+
+							broadcast(v1.TypeQueue, queue.Get())
+
+							sendSnapshot("")
+						}
+					})
+				},
+				ctx,
+			)
+			partyClient = c
 
-			if err := encoder.Encode(mpvCommand{[]interface{}{"set_property", "pause", true}}); err != nil {
+			joinedAt = time.Now()
+			if err := partyClient.Open(); err != nil {
 				openErrorDialog(ctx, window, err)
 
 				return
 			}
 
-			playButton.SetIconName(playIcon)
-		})
+			refreshPartyMembers()
+			syncControlPermissions()
 
-		go func() {
-			if err := command.Wait(); err != nil && err.Error() != errKilled.Error() {
-				openErrorDialog(ctx, window, err)
+			left := make(chan struct{})
+			go func() {
+				t := time.NewTicker(partyPresenceInterval)
+				defer t.Stop()
+
+				for {
+					select {
+					case <-t.C:
+						glib.IdleAdd(func() {
+							if partyClient != c {
+								return
+							}
+
+							syncPresence(c)
+						})
+					case <-left:
+						return
+					}
+				}
+			}()
 
-				return
+			log.Info().
+				Str("server", invite.Server).
+				Str("room", invite.Room).
+				Msg("Joined party")
+
+			go func(c *party.Client) {
+				defer close(left)
+
+				if err := c.Wait(); err != nil {
+					log.Warn().
+						Err(err).
+						Msg("Lost connection to party")
+
+					glib.IdleAdd(func() {
+						overlay.AddToast(adw.NewToast("Lost connection to party."))
+					})
+				}
+			}(partyClient)
+
+			if isHost {
+				broadcast(v1.TypeSelectMedia, v1.SelectMedia{
+					Magnet: magnetLink,
+					Path:   selectedTorrentMedia,
+				})
 			}
+		}
+
+		go func() {
+			err := mediaPlayer.Wait()
 
-			done <- struct{}{}
+			glib.IdleAdd(func() {
+				if err != nil {
+					openErrorDialog(ctx, window, err)
+
+					return
+				}
+
+				closeParty()
 
-			window.Destroy()
+				window.Destroy()
+			})
 		}()
 
 		playButton.GrabFocus()
@@ -1168,7 +3478,10 @@ func addMainMenu(ctx context.Context, app *adw.Application, window *adw.Applicat
 	preferencesBuilder := gtk.NewBuilderFromString(preferencesUI, len(preferencesUI))
 	preferencesWindow := preferencesBuilder.GetObject("preferences-window").Cast().(*adw.PreferencesWindow)
 	storageLocationInput := preferencesBuilder.GetObject("storage-location-input").Cast().(*gtk.Button)
+	playerInput := preferencesBuilder.GetObject("player-input").Cast().(*gtk.ComboBoxText)
 	mpvCommandInput := preferencesBuilder.GetObject("mpv-command-input").Cast().(*gtk.Entry)
+	vlcCommandInput := preferencesBuilder.GetObject("vlc-command-input").Cast().(*gtk.Entry)
+	subtitleLanguageInput := preferencesBuilder.GetObject("subtitle-language-input").Cast().(*gtk.Entry)
 	verbosityLevelInput := preferencesBuilder.GetObject("verbosity-level-input").Cast().(*gtk.SpinButton)
 	remoteGatewaySwitchInput := preferencesBuilder.GetObject("htorrent-remote-gateway-switch").Cast().(*gtk.Switch)
 	remoteGatewayURLInput := preferencesBuilder.GetObject("htorrent-url-input").Cast().(*gtk.Entry)
@@ -1177,6 +3490,16 @@ func addMainMenu(ctx context.Context, app *adw.Application, window *adw.Applicat
 	remoteGatewayURLRow := preferencesBuilder.GetObject("htorrent-url-row").Cast().(*adw.ActionRow)
 	remoteGatewayUsernameRow := preferencesBuilder.GetObject("htorrent-username-row").Cast().(*adw.ActionRow)
 	remoteGatewayPasswordRow := preferencesBuilder.GetObject("htorrent-password-row").Cast().(*adw.ActionRow)
+	partyServerInput := preferencesBuilder.GetObject("party-server-input").Cast().(*gtk.Entry)
+	partyRoomInput := preferencesBuilder.GetObject("party-room-input").Cast().(*gtk.Entry)
+	partyNameInput := preferencesBuilder.GetObject("party-name-input").Cast().(*gtk.Entry)
+	syncSpeedThresholdInput := preferencesBuilder.GetObject("sync-speed-threshold-input").Cast().(*gtk.SpinButton)
+	syncSeekThresholdInput := preferencesBuilder.GetObject("sync-seek-threshold-input").Cast().(*gtk.SpinButton)
+	syncBufferTimeoutInput := preferencesBuilder.GetObject("sync-buffer-timeout-input").Cast().(*gtk.SpinButton)
+	partyChatOSDSwitchInput := preferencesBuilder.GetObject("party-chat-osd-switch").Cast().(*gtk.Switch)
+	partyPeerToPeerSwitchInput := preferencesBuilder.GetObject("party-peer-to-peer-switch").Cast().(*gtk.Switch)
+	partySTUNServersRow := preferencesBuilder.GetObject("party-stun-servers-row").Cast().(*adw.ActionRow)
+	partySTUNServersInput := preferencesBuilder.GetObject("party-stun-servers-input").Cast().(*gtk.Entry)
 
 	preferencesHaveChanged := false
 
@@ -1277,7 +3600,10 @@ func addMainMenu(ctx context.Context, app *adw.Application, window *adw.Applicat
 		filePicker.Show()
 	})
 
+	settings.Bind(playerFlag, playerInput.Object, "active-id", gio.SettingsBindDefault)
 	settings.Bind(mpvFlag, mpvCommandInput.Object, "text", gio.SettingsBindDefault)
+	settings.Bind(vlcFlag, vlcCommandInput.Object, "text", gio.SettingsBindDefault)
+	settings.Bind(subtitleLanguageFlag, subtitleLanguageInput.Object, "text", gio.SettingsBindDefault)
 
 	verbosityLevelInput.SetAdjustment(gtk.NewAdjustment(0, 0, 8, 1, 1, 1))
 	settings.Bind(verboseFlag, verbosityLevelInput.Object, "value", gio.SettingsBindDefault)
@@ -1287,9 +3613,35 @@ func addMainMenu(ctx context.Context, app *adw.Application, window *adw.Applicat
 	settings.Bind(gatewayUsernameFlag, remoteGatewayUsernameInput.Object, "text", gio.SettingsBindDefault)
 	settings.Bind(gatewayPasswordFlag, remoteGatewayPasswordInput.Object, "text", gio.SettingsBindDefault)
 
+	settings.Bind(partyServerFlag, partyServerInput.Object, "text", gio.SettingsBindDefault)
+	settings.Bind(partyRoomFlag, partyRoomInput.Object, "text", gio.SettingsBindDefault)
+	settings.Bind(partyNameFlag, partyNameInput.Object, "text", gio.SettingsBindDefault)
+
+	syncSpeedThresholdInput.SetAdjustment(gtk.NewAdjustment(0, 0, 10000, 50, 100, 0))
+	settings.Bind(syncSpeedThresholdFlag, syncSpeedThresholdInput.Object, "value", gio.SettingsBindDefault)
+
+	syncSeekThresholdInput.SetAdjustment(gtk.NewAdjustment(0, 0, 60000, 500, 1000, 0))
+	settings.Bind(syncSeekThresholdFlag, syncSeekThresholdInput.Object, "value", gio.SettingsBindDefault)
+
+	syncBufferTimeoutInput.SetAdjustment(gtk.NewAdjustment(0, 0, 600, 1, 10, 0))
+	settings.Bind(syncBufferTimeoutFlag, syncBufferTimeoutInput.Object, "value", gio.SettingsBindDefault)
+
+	settings.Bind(partyChatOSDFlag, partyChatOSDSwitchInput.Object, "active", gio.SettingsBindDefault)
+	settings.Bind(partyPeerToPeerFlag, partyPeerToPeerSwitchInput.Object, "active", gio.SettingsBindDefault)
+	settings.Bind(partySTUNServersFlag, partySTUNServersInput.Object, "text", gio.SettingsBindDefault)
+
+	playerInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
 	mpvCommandInput.ConnectChanged(func() {
 		preferencesHaveChanged = true
 	})
+	vlcCommandInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	subtitleLanguageInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
 	verbosityLevelInput.ConnectChanged(func() {
 		preferencesHaveChanged = true
 	})
@@ -1314,6 +3666,48 @@ func addMainMenu(ctx context.Context, app *adw.Application, window *adw.Applicat
 		preferencesHaveChanged = true
 	})
 
+	partyServerInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	partyRoomInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	partyNameInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	syncSpeedThresholdInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	syncSeekThresholdInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+	syncBufferTimeoutInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+
+	partyChatOSDSwitchInput.ConnectStateSet(func(state bool) (ok bool) {
+		preferencesHaveChanged = true
+
+		partyChatOSDSwitchInput.SetState(state)
+
+		return true
+	})
+
+	partyPeerToPeerSwitchInput.ConnectStateSet(func(state bool) (ok bool) {
+		preferencesHaveChanged = true
+
+		partyPeerToPeerSwitchInput.SetState(state)
+
+		partySTUNServersRow.SetSensitive(state)
+
+		return true
+	})
+	partySTUNServersRow.SetSensitive(settings.Boolean(partyPeerToPeerFlag))
+
+	partySTUNServersInput.ConnectChanged(func() {
+		preferencesHaveChanged = true
+	})
+
 	aboutAction := gio.NewSimpleAction("about", nil)
 	aboutAction.ConnectActivate(func(parameter *glib.Variant) {
 		aboutDialog.Show()
@@ -1420,7 +3814,7 @@ func main() {
 		}
 	})
 
-	app := adw.NewApplication(appID, gio.ApplicationFlags(gio.ApplicationFlagsNone))
+	app := adw.NewApplication(appID, gio.ApplicationFlags(gio.ApplicationHandlesOpen))
 
 	prov := gtk.NewCSSProvider()
 	prov.LoadFromData(styleCSS)
@@ -1428,7 +3822,15 @@ func main() {
 	var gateway *server.Gateway
 	ctx, cancel := context.WithCancel(context.Background())
 
-	app.ConnectActivate(func() {
+	var manager *client.Manager
+	apiAddr := ""
+	apiUsername := ""
+	apiPassword := ""
+	setup := func() {
+		if manager != nil {
+			return
+		}
+
 		gtk.StyleContextAddProviderForDisplay(
 			gdk.DisplayGetDefault(),
 			prov,
@@ -1452,9 +3854,9 @@ func main() {
 			panic(err)
 		}
 
-		apiAddr := settings.String(gatewayURLFlag)
-		apiUsername := settings.String(gatewayUsernameFlag)
-		apiPassword := settings.String(gatewayPasswordFlag)
+		apiAddr = settings.String(gatewayURLFlag)
+		apiUsername = settings.String(gatewayUsernameFlag)
+		apiPassword = settings.String(gatewayPasswordFlag)
 		if !settings.Boolean(gatewayRemoteFlag) {
 			apiUsername = randSeq(20)
 			apiPassword = randSeq(20)
@@ -1474,6 +3876,12 @@ func main() {
 						Int64("completed", completed).
 						Str("path", path).
 						Msg("Streaming")
+
+					setDownloadProgress(path, downloadProgress{
+						peers:     peers,
+						total:     total,
+						completed: completed,
+					})
 				},
 				ctx,
 			)
@@ -1495,18 +3903,36 @@ func main() {
 			apiAddr = "http://" + addr.String()
 		}
 
-		manager := client.NewManager(
+		manager = client.NewManager(
 			apiAddr,
 			apiUsername,
 			apiPassword,
 			ctx,
 		)
+	}
+
+	app.ConnectActivate(func() {
+		setup()
 
-		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir); err != nil {
+		if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir, ""); err != nil {
 			panic(err)
 		}
 	})
 
+	app.ConnectOpen(func(files []gio.Filer, hint string) {
+		setup()
+
+		for _, file := range files {
+			log.Info().
+				Str("uri", file.URI()).
+				Msg("Opening from URI")
+
+			if err := openAssistantWindow(ctx, app, manager, apiAddr, apiUsername, apiPassword, settings, gateway, cancel, tmpDir, file.URI()); err != nil {
+				panic(err)
+			}
+		}
+	})
+
 	app.ConnectShutdown(func() {
 		cancel()
 
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pojntfx/vintangle/pkg/party"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errInvalidMemberTimeout = errors.New("could not work with non-positive member timeout")
+	errInvalidRoomTTL       = errors.New("could not work with negative room TTL")
+)
+
+func main() {
+	verbose := flag.Int("verbose", 5, "Verbosity level (0 is disabled, default is info, 7 is trace)")
+	laddr := flag.String("laddr", ":1337", "Listen address")
+	memberTimeout := flag.Duration("member-timeout", time.Second*15, "Time after which members that haven't sent a message or heartbeat are disconnected")
+	roomTTL := flag.Duration("room-ttl", time.Minute*10, "Time after which empty rooms are removed (0 keeps them forever)")
+
+	flag.Parse()
+
+	if *memberTimeout <= 0 {
+		panic(errInvalidMemberTimeout)
+	}
+
+	if *roomTTL < 0 {
+		panic(errInvalidRoomTTL)
+	}
+
+	switch *verbose {
+	case 0:
+		zerolog.SetGlobalLevel(zerolog.Disabled)
+	case 1:
+		zerolog.SetGlobalLevel(zerolog.PanicLevel)
+	case 2:
+		zerolog.SetGlobalLevel(zerolog.FatalLevel)
+	case 3:
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	case 4:
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	case 5:
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	case 6:
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	default:
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay := party.NewRelay(
+		*laddr,
+		*memberTimeout,
+		*roomTTL,
+		ctx,
+	)
+
+	if err := relay.Open(); err != nil {
+		panic(err)
+	}
+
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-s
+
+		log.Debug().Msg("Gracefully shutting down")
+
+		go func() {
+			<-s
+
+			log.Debug().Msg("Forcing shutdown")
+
+			cancel()
+
+			os.Exit(1)
+		}()
+
+		if err := relay.Close(); err != nil {
+			panic(err)
+		}
+
+		cancel()
+	}()
+
+	log.Info().
+		Str("address", relay.Addr().String()).
+		Msg("Relay listening")
+
+	if err := relay.Wait(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,209 @@
+package v1
+
+import "encoding/json"
+
+const (
+	Version = 1
+
+	TypeJoin            = "join"
+	TypeLeave           = "leave"
+	TypePlay            = "play"
+	TypePause           = "pause"
+	TypeSeek            = "seek"
+	TypeSelectMedia     = "select-media"
+	TypeSelectSubtitles = "select-subtitles"
+	TypeHeartbeat       = "heartbeat"
+	TypePosition        = "position"
+	TypeBufferState     = "buffer-state"
+	TypeRoles           = "roles"
+	TypeKick            = "kick"
+	TypeRequest         = "request"
+	TypeClockRequest    = "clock-request"
+	TypeClockResponse   = "clock-response"
+	TypeSchedule        = "schedule"
+	TypeChat            = "chat"
+	TypePresence        = "presence"
+	TypeRekey           = "rekey"
+	TypeSignal          = "signal"
+	TypeSnapshot        = "snapshot"
+	TypeReaction        = "reaction"
+	TypePoll            = "poll"
+	TypeBallot          = "ballot"
+	TypeQueue           = "queue"
+	TypeSuggestion      = "suggestion"
+
+	RoleHost   = "host"
+	RoleCoHost = "co-host"
+	RoleGuest  = "guest"
+)
+
+type Message struct {
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Room      string          `json:"room"`
+	From      string          `json:"from"`
+	Timestamp int64           `json:"timestamp"`         // Party clock time in Unix nanoseconds
+	Payload   json.RawMessage `json:"payload,omitempty"` // Sealed payload; only leave messages synthesized by the relay are sent in plaintext
+	Token     string          `json:"token,omitempty"`   // Secret that lets a member resume its connection to the relay; only sent in joins, which the relay forwards without it
+}
+
+type Sealed struct {
+	Epoch      int    `json:"epoch"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type Join struct {
+	Name      string `json:"name"`
+	PublicKey []byte `json:"publicKey"` // X25519 public key the host wraps rotated keys for
+}
+
+type Leave struct{}
+
+type Play struct {
+	Position float64 `json:"position"`
+}
+
+type Pause struct {
+	Position float64 `json:"position"`
+}
+
+type Seek struct {
+	Position float64 `json:"position"`
+}
+
+type SelectMedia struct {
+	Magnet string `json:"magnet"`
+	Path   string `json:"path"`
+}
+
+type SelectSubtitles struct {
+	Path  string         `json:"path"`            // Path of the subtitles in the torrent; empty disables subtitles unless a track is set
+	Track *SubtitleTrack `json:"track,omitempty"` // Embedded subtitle track to show instead of a file
+}
+
+// SubtitleTrack refers to an embedded subtitle track independently of the player, since each player has its own track IDs
+type SubtitleTrack struct {
+	Index int    `json:"index"` // Position among the embedded subtitle tracks of the media, starting at 0
+	Lang  string `json:"lang"`
+	Title string `json:"title"`
+}
+
+type Heartbeat struct{}
+
+type Position struct {
+	Position float64 `json:"position"`
+	Paused   bool    `json:"paused"`
+}
+
+type BufferState struct {
+	Ready     bool    `json:"ready"`
+	Cache     float64 `json:"cache"` // Seconds of media that are buffered ahead of the current position
+	Total     int64   `json:"total"`
+	Completed int64   `json:"completed"`
+}
+
+type Roles struct {
+	Host       string   `json:"host"`
+	CoHosts    []string `json:"coHosts"`
+	Kicked     []string `json:"kicked"`
+	Restricted bool     `json:"restricted"` // Whether only the host and co-hosts may control playback
+}
+
+type Kick struct {
+	Member string `json:"member"`
+}
+
+type Request struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type ClockRequest struct {
+	Origin int64 `json:"origin"` // Local time of the requester when sending the request
+}
+
+type ClockResponse struct {
+	To       string `json:"to"`
+	Origin   int64  `json:"origin"`
+	Receive  int64  `json:"receive"`  // Party clock time when the request was received
+	Transmit int64  `json:"transmit"` // Party clock time when the response was sent
+}
+
+type Schedule struct {
+	At       int64   `json:"at"` // Party clock time in Unix nanoseconds to start playback at; 0 cancels the schedule
+	Position float64 `json:"position"`
+}
+
+type Chat struct {
+	Name string `json:"name"` // Name of the sender, so that messages stay readable after they have left
+	Text string `json:"text"`
+}
+
+type Presence struct {
+	Position  float64 `json:"position"`
+	Paused    bool    `json:"paused"`
+	Buffering bool    `json:"buffering"`
+	RTT       int64   `json:"rtt"` // Round trip time to the host in nanoseconds
+	Peers     int     `json:"peers"`
+	Total     int64   `json:"total"`
+	Completed int64   `json:"completed"`
+}
+
+type Rekey struct {
+	Epoch int               `json:"epoch"`
+	Keys  map[string][]byte `json:"keys"` // Key of the epoch, wrapped for each member
+}
+
+// Signal is used to negotiate direct connections between members; it is handled by the transport, which seals it with the party key too
+type Signal struct {
+	To      string `json:"to"`
+	SDPType string `json:"sdpType"`
+	SDP     string `json:"sdp"`
+}
+
+type Snapshot struct {
+	To            string         `json:"to"`
+	Magnet        string         `json:"magnet"`
+	Path          string         `json:"path"`
+	Subtitles     string         `json:"subtitles"`               // Path of the active subtitles in the torrent; empty if subtitles are disabled
+	SubtitleTrack *SubtitleTrack `json:"subtitleTrack,omitempty"` // Active embedded subtitle track, if any
+	SubtitleDelay float64        `json:"subtitleDelay"`           // Subtitle delay in seconds
+	Paused        bool           `json:"paused"`
+	Position      float64        `json:"position"` // Position at the time the message was sent
+}
+
+type Reaction struct {
+	Name  string `json:"name"` // Name of the sender, which is shown next to the reaction
+	Emoji string `json:"emoji"`
+}
+
+type Poll struct {
+	ID         string            `json:"id"`
+	Candidates []string          `json:"candidates"` // Paths of the files in the torrent that can be voted for
+	Ballots    map[string]string `json:"ballots"`    // Candidate each member voted for, as counted by the host
+	Closed     bool              `json:"closed"`
+}
+
+type Ballot struct {
+	Poll      string `json:"poll"`
+	Candidate string `json:"candidate"`
+}
+
+type QueueItem struct {
+	ID          string `json:"id"`
+	Magnet      string `json:"magnet"`
+	Path        string `json:"path"`
+	Title       string `json:"title"`       // Name of the torrent
+	SuggestedBy string `json:"suggestedBy"` // Name of the member that suggested the item; empty if the host added it
+}
+
+type Queue struct {
+	Items []QueueItem `json:"items"` // Items that will be played after the current media, in order
+}
+
+type Suggestion struct {
+	Magnet string `json:"magnet"`
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+}
@@ -0,0 +1,399 @@
+package controls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pojntfx/vintangle/pkg/player"
+	"github.com/pojntfx/vintangle/pkg/subtitle"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Subtitles are much smaller than this, so larger files such as extra files from the media are copied without being converted
+	maxSubtitlesSize = 16 * 1024 * 1024
+)
+
+// Controls drives playback in the player independently of the UI, which makes it possible to run it against a fake player
+type Controls struct {
+	player         player.Player
+	cacheDir       string
+	fetchSubtitles func(path string) (io.ReadCloser, error)
+
+	activeSubtitles     string
+	activeSubtitleTrack int
+	activeSubtitlesLock sync.Mutex
+
+	subtitlesFilesLock sync.Mutex
+
+	ctx context.Context
+}
+
+// NewControls creates controls that keep converted subtitles in `cacheDir`, which must only be used for one media
+func NewControls(
+	player player.Player,
+	cacheDir string,
+	fetchSubtitles func(path string) (io.ReadCloser, error),
+
+	ctx context.Context,
+) *Controls {
+	return &Controls{
+		player:         player,
+		cacheDir:       cacheDir,
+		fetchSubtitles: fetchSubtitles,
+
+		ctx: ctx,
+	}
+}
+
+func (c *Controls) Subscribe(subscriber func(previous, current player.State)) {
+	c.player.Subscribe(subscriber)
+}
+
+func (c *Controls) State() player.State {
+	return c.player.State()
+}
+
+func (c *Controls) SetPaused(paused bool) error {
+	if paused {
+		log.Info().Msg("Pausing playback")
+	} else {
+		log.Info().Msg("Starting playback")
+	}
+
+	return c.player.SetPaused(paused)
+}
+
+func (c *Controls) Seek(position time.Duration) error {
+	log.Info().
+		Dur("position", position).
+		Msg("Seeking")
+
+	return c.player.Seek(position)
+}
+
+// SeekPrecision returns the granularity in which the player can seek; zero if it can seek to any position
+func (c *Controls) SeekPrecision() time.Duration {
+	return c.player.SeekPrecision()
+}
+
+// SetSpeed changes the playback speed, i.e. to catch up with the host
+func (c *Controls) SetSpeed(speed float64) error {
+	return c.player.SetSpeed(speed)
+}
+
+// SetVolume sets the volume from 0 to 1
+func (c *Controls) SetVolume(volume float64) error {
+	log.Info().
+		Float64("value", volume).
+		Msg("Setting volume")
+
+	return c.player.SetVolume(volume)
+}
+
+func (c *Controls) SetFullscreen(fullscreen bool) error {
+	log.Info().
+		Bool("fullscreen", fullscreen).
+		Msg("Setting fullscreen")
+
+	return c.player.SetFullscreen(fullscreen)
+}
+
+// SetSubtitles downloads the subtitles from the media, converts them to a format that the player can show and shows them
+func (c *Controls) SetSubtitles(m string) error {
+	subtitlesFile, err := c.getSubtitlesFile(m)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("path", subtitlesFile).
+		Msg("Setting subtitles")
+
+	if err := c.player.SetSubtitlesFile(subtitlesFile); err != nil {
+		return err
+	}
+
+	c.setActiveSubtitles(m, 0)
+
+	return nil
+}
+
+// getSubtitlesFile returns the converted subtitles from the media, downloading them only if they haven't been converted before
+func (c *Controls) getSubtitlesFile(m string) (string, error) {
+	c.subtitlesFilesLock.Lock()
+	defer c.subtitlesFilesLock.Unlock()
+
+	if subtitlesFile, ok := c.findSubtitlesFile(m); ok {
+		return subtitlesFile, nil
+	}
+
+	log.Info().
+		Str("path", m).
+		Msg("Downloading subtitles")
+
+	src, err := c.fetchSubtitles(m)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return c.writeSubtitlesFile(m, src)
+}
+
+// getCacheDir returns the directory that the converted file with the name is kept in; files from different directories can have the same name
+func (c *Controls) getCacheDir(name string) string {
+	hash := sha1.Sum([]byte(name))
+
+	return filepath.Join(c.cacheDir, hex.EncodeToString(hash[:]))
+}
+
+// findSubtitlesFile returns the converted subtitles if they have been converted before, i.e. in a previous session
+func (c *Controls) findSubtitlesFile(name string) (string, bool) {
+	dir := c.getCacheDir(name)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for _, entry := range entries {
+		// Temporary files are hidden
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			return filepath.Join(dir, entry.Name()), true
+		}
+	}
+
+	return "", false
+}
+
+// writeSubtitlesFile converts the subtitles to UTF-8 and to a format that the player supports and writes them to the cache
+func (c *Controls) writeSubtitlesFile(name string, src io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(src, maxSubtitlesSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > maxSubtitlesSize {
+		log.Debug().
+			Str("path", name).
+			Msg("File is too large to be subtitles, copying it without converting it")
+
+		return c.writeCacheFile(name, path.Ext(name), io.MultiReader(bytes.NewReader(data), src))
+	}
+
+	data, format, encoding, err := subtitle.Normalize(data, subtitle.DetectLanguage(name))
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().
+		Str("path", name).
+		Str("format", string(format)).
+		Str("encoding", encoding).
+		Msg("Converted subtitles")
+
+	// Players detect the format from the extension
+	ext := path.Ext(name)
+	if format != subtitle.FormatUnknown {
+		ext = "." + string(format)
+	}
+
+	return c.writeCacheFile(name, ext, bytes.NewReader(data))
+}
+
+// writeCacheFile replaces the cached file with the name; it is only visible once it has been written completely
+func (c *Controls) writeCacheFile(name, ext string, src io.Reader) (string, error) {
+	dir := c.getCacheDir(name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	dst, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(dst.Name())
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+
+		return "", err
+	}
+
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	cacheFile := filepath.Join(dir, strings.TrimSuffix(path.Base(name), path.Ext(name))+ext)
+	if err := os.Rename(dst.Name(), cacheFile); err != nil {
+		return "", err
+	}
+
+	return cacheFile, nil
+}
+
+// SetLocalSubtitles shows subtitles from a local file; since other party members can't access it, it is not reported as active
+func (c *Controls) SetLocalSubtitles(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Local files can be changed between selections, so they are converted again every time
+	c.subtitlesFilesLock.Lock()
+	subtitlesFile, err := c.writeSubtitlesFile(file, src)
+	c.subtitlesFilesLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("path", subtitlesFile).
+		Msg("Setting subtitles")
+
+	if err := c.player.SetSubtitlesFile(subtitlesFile); err != nil {
+		return err
+	}
+
+	c.setActiveSubtitles("", 0)
+
+	return nil
+}
+
+func (c *Controls) DisableSubtitles() error {
+	log.Info().
+		Msg("Disabling subtitles")
+
+	if err := c.player.ClearSubtitles(); err != nil {
+		return err
+	}
+
+	// Embedded subtitles are still shown after removing the external ones
+	if err := c.player.SelectTrack(player.TrackTypeSubtitles, 0); err != nil {
+		return err
+	}
+
+	c.setActiveSubtitles("", 0)
+
+	return nil
+}
+
+// SetSubtitleStyle applies the style to the subtitles; players that can't change parts of it keep their defaults for those
+func (c *Controls) SetSubtitleStyle(style SubtitleStyle) error {
+	log.Info().
+		Float64("delay", style.Delay).
+		Float64("scale", style.Scale).
+		Int("position", style.Position).
+		Str("font", style.Font).
+		Msg("Setting subtitle style")
+
+	if err := c.player.SetSubtitleDelay(time.Duration(style.Delay * float64(time.Second))); err != nil && !errors.Is(err, player.ErrUnsupported) {
+		return err
+	}
+
+	if err := c.player.SetSubtitleScale(style.Scale); err != nil && !errors.Is(err, player.ErrUnsupported) {
+		return err
+	}
+
+	if err := c.player.SetSubtitlePosition(style.Position); err != nil && !errors.Is(err, player.ErrUnsupported) {
+		return err
+	}
+
+	if err := c.player.SetSubtitleFont(style.Font); err != nil && !errors.Is(err, player.ErrUnsupported) {
+		return err
+	}
+
+	return nil
+}
+
+// SetSubtitleTrack shows the embedded subtitle track with the ID
+func (c *Controls) SetSubtitleTrack(id int) error {
+	log.Info().
+		Int("id", id).
+		Msg("Setting subtitle track")
+
+	if err := c.player.SelectTrack(player.TrackTypeSubtitles, id); err != nil {
+		return err
+	}
+
+	c.setActiveSubtitles("", id)
+
+	return nil
+}
+
+func (c *Controls) SetAudioTrack(id int) error {
+	log.Info().
+		Int("id", id).
+		Msg("Setting audio track")
+
+	return c.player.SelectTrack(player.TrackTypeAudio, id)
+}
+
+func (c *Controls) SetVideoTrack(id int) error {
+	log.Info().
+		Int("id", id).
+		Msg("Setting video track")
+
+	return c.player.SelectTrack(player.TrackTypeVideo, id)
+}
+
+func (c *Controls) SetSubtitleDelay(delay time.Duration) error {
+	log.Info().
+		Dur("delay", delay).
+		Msg("Setting subtitle delay")
+
+	return c.player.SetSubtitleDelay(delay)
+}
+
+func (c *Controls) setActiveSubtitles(m string, track int) {
+	c.activeSubtitlesLock.Lock()
+	defer c.activeSubtitlesLock.Unlock()
+
+	c.activeSubtitles = m
+	c.activeSubtitleTrack = track
+}
+
+// ActiveSubtitles returns the path of the subtitles in the media that are being shown, if any
+func (c *Controls) ActiveSubtitles() string {
+	c.activeSubtitlesLock.Lock()
+	defer c.activeSubtitlesLock.Unlock()
+
+	return c.activeSubtitles
+}
+
+// ActiveSubtitleTrack returns the ID of the embedded subtitle track that is being shown, if any
+func (c *Controls) ActiveSubtitleTrack() int {
+	c.activeSubtitlesLock.Lock()
+	defer c.activeSubtitlesLock.Unlock()
+
+	return c.activeSubtitleTrack
+}
+
+// ShowText shows the text on top of the video for the given duration
+func (c *Controls) ShowText(text string, duration time.Duration) error {
+	return c.player.ShowText(text, duration)
+}
+
+func (c *Controls) SetOverlay(id int, data string) error {
+	return c.player.SetOverlay(id, data)
+}
+
+func (c *Controls) RemoveOverlay(id int) error {
+	return c.player.RemoveOverlay(id)
+}
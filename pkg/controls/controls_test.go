@@ -0,0 +1,503 @@
+package controls
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/pojntfx/vintangle/pkg/mpvtest"
+	"github.com/pojntfx/vintangle/pkg/party"
+	"github.com/pojntfx/vintangle/pkg/player"
+)
+
+const (
+	testTimeout = time.Second * 5
+)
+
+type testSubtitles struct {
+	files map[string][]byte
+
+	fetched     map[string]int
+	fetchedLock sync.Mutex
+}
+
+func (s *testSubtitles) fetch(path string) (io.ReadCloser, error) {
+	s.fetchedLock.Lock()
+	defer s.fetchedLock.Unlock()
+
+	s.fetched[path]++
+
+	data, ok := s.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *testSubtitles) count(path string) int {
+	s.fetchedLock.Lock()
+	defer s.fetchedLock.Unlock()
+
+	return s.fetched[path]
+}
+
+func openTestControls(t *testing.T, files map[string][]byte, cacheDir string) (*Controls, *mpvtest.Server, *testSubtitles) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// Unix socket paths can't be very long, so we don't use the test's temporary directory here
+	ipcDir, err := os.MkdirTemp("", "mpvtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(ipcDir)
+	})
+
+	ipcFile := filepath.Join(ipcDir, "mpv.sock")
+
+	server := mpvtest.NewServer(ipcFile, ctx)
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	mpv := player.NewMPV("mpv", testTimeout, ctx)
+	if err := mpv.Connect(ipcFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = mpv.Close()
+	})
+
+	subtitles := &testSubtitles{
+		files:   files,
+		fetched: map[string]int{},
+	}
+
+	return NewControls(mpv, cacheDir, subtitles.fetch, ctx), server, subtitles
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", description)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// lastCommand returns the last command with the name that the fake mpv has received
+func lastCommand(server *mpvtest.Server, name string) (mpvtest.Command, bool) {
+	commands := server.Commands()
+	for i := len(commands) - 1; i >= 0; i-- {
+		if commands[i].Name == name {
+			return commands[i], true
+		}
+	}
+
+	return mpvtest.Command{}, false
+}
+
+func TestControlsPlayback(t *testing.T) {
+	controls, server, _ := openTestControls(t, nil, t.TempDir())
+
+	server.SetProperty("duration", float64(100))
+	server.SetProperty("time-pos", float64(0))
+
+	waitFor(t, "media to load", func() bool {
+		return controls.State().Duration == time.Second*100
+	})
+
+	if !controls.State().Paused {
+		t.Fatal("player isn't paused after launching")
+	}
+
+	if err := controls.SetPaused(false); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "playback to start", func() bool {
+		return !controls.State().Paused
+	})
+
+	tests := []struct {
+		name     string
+		position time.Duration
+		expected time.Duration
+	}{
+		{"forward", time.Second * 30, time.Second * 30},
+		{"backward", time.Second * 10, time.Second * 10},
+		{"past the end", time.Second * 200, time.Second * 100},
+		{"before the start", -time.Second * 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := controls.Seek(tt.position); err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, "seek", func() bool {
+				return controls.State().Position == tt.expected
+			})
+		})
+	}
+
+	if err := controls.SetVolume(0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	if volume, _ := server.Property("volume"); volume != float64(50) {
+		t.Fatalf("expected volume 50, got %v", volume)
+	}
+}
+
+func TestControlsSubtitles(t *testing.T) {
+	const (
+		media     = "Show/Subs/Show.S01E01.en.vtt"
+		extraFile = "Show/Info.nfo"
+	)
+
+	files := map[string][]byte{
+		media:     []byte("WEBVTT\r\n\r\n00:01.000 --> 00:02.500 align:start\r\n<v Narrator>Hello</v>\r\n"),
+		extraFile: []byte("Not subtitles"),
+	}
+	cacheDir := t.TempDir()
+
+	controls, server, subtitles := openTestControls(t, files, cacheDir)
+
+	for i := 0; i < 2; i++ {
+		if err := controls.SetSubtitles(media); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Subtitles are only downloaded once
+	if count := subtitles.count(media); count != 1 {
+		t.Fatalf("expected subtitles to be downloaded once, got %v", count)
+	}
+
+	if controls.ActiveSubtitles() != media {
+		t.Fatalf("expected active subtitles %v, got %v", media, controls.ActiveSubtitles())
+	}
+
+	add, ok := lastCommand(server, "sub-add")
+	if !ok {
+		t.Fatal("subtitles haven't been added to the player")
+	}
+
+	file, ok := add.Args[0].(string)
+	if !ok || filepath.Ext(file) != ".srt" {
+		t.Fatalf("expected subtitles to be converted to SRT, got %v", add.Args[0])
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n"; string(data) != expected {
+		t.Fatalf("expected converted subtitles %q, got %q", expected, data)
+	}
+
+	if files, _ := server.Property("sub-files"); len(files.([]interface{})) != 1 {
+		t.Fatalf("expected one subtitle file, got %v", files)
+	}
+
+	// Other files are passed to the player as they are
+	if err := controls.SetSubtitles(extraFile); err != nil {
+		t.Fatal(err)
+	}
+
+	add, _ = lastCommand(server, "sub-add")
+	if file, _ := add.Args[0].(string); filepath.Ext(file) != ".nfo" {
+		t.Fatalf("expected extra file to keep its extension, got %v", add.Args[0])
+	}
+
+	if files, _ := server.Property("sub-files"); len(files.([]interface{})) != 1 {
+		t.Fatalf("expected previous subtitles to be replaced, got %v", files)
+	}
+
+	if err := controls.DisableSubtitles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := server.Property("sub-files"); len(files.([]interface{})) != 0 {
+		t.Fatalf("expected subtitles to be removed, got %v", files)
+	}
+
+	if sid, _ := server.Property("sid"); sid != "no" {
+		t.Fatalf("expected embedded subtitles to be disabled, got %v", sid)
+	}
+
+	if controls.ActiveSubtitles() != "" {
+		t.Fatalf("expected no active subtitles, got %v", controls.ActiveSubtitles())
+	}
+
+	if err := controls.SetSubtitles("Show/Missing.srt"); err == nil {
+		t.Fatal("could set subtitles that don't exist")
+	}
+
+	// Converted subtitles are kept across sessions
+	reopened, _, resubtitles := openTestControls(t, files, cacheDir)
+	if err := reopened.SetSubtitles(media); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := resubtitles.count(media); count != 0 {
+		t.Fatalf("expected cached subtitles to be used, got %v downloads", count)
+	}
+}
+
+func TestControlsLargeFile(t *testing.T) {
+	const extraFile = "Movie/Sample.mkv"
+
+	data := bytes.Repeat([]byte{0, 1, 2, 3}, maxSubtitlesSize/4+1)
+
+	controls, server, _ := openTestControls(t, map[string][]byte{
+		extraFile: data,
+	}, t.TempDir())
+
+	if err := controls.SetSubtitles(extraFile); err != nil {
+		t.Fatal(err)
+	}
+
+	add, _ := lastCommand(server, "sub-add")
+	file, _ := add.Args[0].(string)
+	if filepath.Ext(file) != ".mkv" {
+		t.Fatalf("expected large file to keep its extension, got %v", add.Args[0])
+	}
+
+	copied, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(copied, data) {
+		t.Fatal("large file has been changed while copying it")
+	}
+}
+
+func TestControlsSubtitleTrack(t *testing.T) {
+	controls, server, _ := openTestControls(t, nil, t.TempDir())
+
+	server.SetProperty("track-list", []interface{}{
+		map[string]interface{}{"id": 1, "type": "video", "selected": true},
+		map[string]interface{}{"id": 1, "type": "sub", "lang": "en", "selected": true},
+		map[string]interface{}{"id": 2, "type": "sub", "lang": "de", "selected": false},
+	})
+
+	waitFor(t, "tracks to load", func() bool {
+		return len(controls.State().Tracks) == 3
+	})
+
+	if err := controls.SetSubtitleTrack(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if controls.ActiveSubtitleTrack() != 2 {
+		t.Fatalf("expected active subtitle track 2, got %v", controls.ActiveSubtitleTrack())
+	}
+
+	waitFor(t, "subtitle track to be selected", func() bool {
+		selected := []string{}
+		for _, track := range controls.State().Tracks {
+			if track.Selected {
+				selected = append(selected, track.Type+"/"+track.Lang)
+			}
+		}
+
+		return strings.Join(selected, ",") == "video/,sub/de"
+	})
+}
+
+func TestControlsSelectSubtitleTrack(t *testing.T) {
+	controls, server, _ := openTestControls(t, nil, t.TempDir())
+
+	// Like VLC, the IDs are unique across all types of tracks
+	server.SetProperty("track-list", []interface{}{
+		map[string]interface{}{"id": 1, "type": "video", "selected": true},
+		map[string]interface{}{"id": 2, "type": "sub", "lang": "en", "selected": false},
+		map[string]interface{}{"id": 3, "type": "sub", "lang": "de", "title": "Forced", "selected": false},
+		map[string]interface{}{"id": 4, "type": "sub", "lang": "de", "selected": false},
+		map[string]interface{}{"id": 5, "type": "sub", "lang": "fr", "external": true, "selected": false},
+	})
+
+	waitFor(t, "tracks to load", func() bool {
+		return len(controls.State().Tracks) == 5
+	})
+
+	if track := controls.SubtitleTrack(4); track == nil || *track != (v1.SubtitleTrack{Index: 2, Lang: "de"}) {
+		t.Fatalf("expected reference to third embedded subtitle track, got %v", track)
+	}
+
+	if track := controls.SubtitleTrack(5); track != nil {
+		t.Fatalf("expected no reference to external subtitle track, got %v", track)
+	}
+
+	tests := []struct {
+		name      string
+		reference v1.SubtitleTrack
+		track     int
+	}{
+		{"index", v1.SubtitleTrack{Index: 1, Lang: "de", Title: "Forced"}, 3},
+		{"language and title", v1.SubtitleTrack{Index: 0, Lang: "de"}, 4},
+		{"index without matching language", v1.SubtitleTrack{Index: 0, Lang: "es"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := controls.SelectSubtitles(v1.SelectSubtitles{Track: &tt.reference}); err != nil {
+				t.Fatal(err)
+			}
+
+			if controls.ActiveSubtitleTrack() != tt.track {
+				t.Fatalf("expected active subtitle track %v, got %v", tt.track, controls.ActiveSubtitleTrack())
+			}
+		})
+	}
+
+	// Tracks that the member doesn't have keep the current subtitles
+	if err := controls.SelectSubtitles(v1.SelectSubtitles{Track: &v1.SubtitleTrack{Index: 5, Lang: "es"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if controls.ActiveSubtitleTrack() != 2 {
+		t.Fatalf("expected active subtitle track 2, got %v", controls.ActiveSubtitleTrack())
+	}
+}
+
+func TestControlsApplySnapshot(t *testing.T) {
+	const subtitles = "Movie.en.srt"
+
+	controls, server, _ := openTestControls(t, map[string][]byte{
+		subtitles: []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n\n"),
+	}, t.TempDir())
+
+	server.SetProperty("duration", float64(100))
+	server.SetProperty("time-pos", float64(0))
+	server.SetProperty("track-list", []interface{}{
+		map[string]interface{}{"id": 1, "type": "sub", "lang": "en", "selected": false},
+		map[string]interface{}{"id": 2, "type": "sub", "lang": "de", "selected": false},
+	})
+
+	waitFor(t, "tracks to load", func() bool {
+		return len(controls.State().Tracks) == 2
+	})
+
+	tests := []struct {
+		name     string
+		snapshot v1.Snapshot
+		elapsed  time.Duration
+		position float64
+		track    int
+	}{
+		{"paused", v1.Snapshot{Subtitles: subtitles, SubtitleDelay: 0.5, Paused: true, Position: 20}, time.Second * 3, 20, 0},
+		{"playing", v1.Snapshot{SubtitleTrack: &v1.SubtitleTrack{Index: 1, Lang: "de"}, Position: 20}, time.Second * 3, 23, 2},
+		{"without subtitles", v1.Snapshot{Paused: true, Position: 40}, 0, 40, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := controls.ApplySnapshot(tt.snapshot, tt.elapsed); err != nil {
+				t.Fatal(err)
+			}
+
+			if position, _ := server.Property("time-pos"); position != tt.position {
+				t.Fatalf("expected position %v, got %v", tt.position, position)
+			}
+
+			if paused, _ := server.Property("pause"); paused != tt.snapshot.Paused {
+				t.Fatalf("expected paused to be %v, got %v", tt.snapshot.Paused, paused)
+			}
+
+			if controls.ActiveSubtitles() != tt.snapshot.Subtitles || controls.ActiveSubtitleTrack() != tt.track {
+				t.Fatalf("expected subtitles %v and track %v, got %v and %v", tt.snapshot.Subtitles, tt.track, controls.ActiveSubtitles(), controls.ActiveSubtitleTrack())
+			}
+
+			if delay, _ := server.Property("sub-delay"); delay != tt.snapshot.SubtitleDelay {
+				t.Fatalf("expected subtitle delay %v, got %v", tt.snapshot.SubtitleDelay, delay)
+			}
+		})
+	}
+}
+
+func TestFollower(t *testing.T) {
+	controls, server, _ := openTestControls(t, nil, t.TempDir())
+
+	server.SetProperty("duration", float64(100))
+	server.SetProperty("time-pos", float64(0))
+
+	follower := NewFollower(controls, party.NewDriftCorrector(time.Millisecond*100, time.Second, 0.1, time.Second*10))
+
+	now := time.Now()
+
+	// Nothing happens until the host has reported its position
+	if err := follower.Sync(0, false, now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		host     time.Duration
+		paused   bool
+		local    time.Duration
+		speed    float64
+		position float64
+	}{
+		{"in sync", time.Second * 10, false, time.Second * 10, 1, 0},
+		{"slightly behind", time.Second * 10, false, time.Millisecond * 9500, 1.05, 0},
+		{"slightly ahead", time.Second * 10, false, time.Millisecond * 10500, 0.95, 0},
+		{"far behind", time.Second * 30, false, time.Second * 10, 1, 30},
+		{"host paused", time.Second * 50, true, time.Second * 10, 1, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.SetProperty("time-pos", float64(0))
+
+			follower.SetReference(tt.host, tt.paused, now)
+
+			if err := follower.Sync(tt.local, false, now); err != nil {
+				t.Fatal(err)
+			}
+
+			if speed, _ := server.Property("speed"); speed != tt.speed {
+				t.Fatalf("expected speed %v, got %v", tt.speed, speed)
+			}
+
+			if position, _ := server.Property("time-pos"); position != tt.position {
+				t.Fatalf("expected position %v, got %v", tt.position, position)
+			}
+		})
+	}
+
+	// Local pauses are left alone
+	server.SetProperty("time-pos", float64(0))
+	follower.SetReference(time.Second*80, false, now)
+
+	if err := follower.Sync(time.Second*10, true, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if position, _ := server.Property("time-pos"); position != float64(0) {
+		t.Fatalf("follower seeked while paused to %v", position)
+	}
+}
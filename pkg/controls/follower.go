@@ -0,0 +1,86 @@
+package controls
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pojntfx/vintangle/pkg/party"
+	"github.com/rs/zerolog/log"
+)
+
+// Follower keeps the local player in sync with the position that the host reports
+type Follower struct {
+	controls  *Controls
+	corrector *party.DriftCorrector
+
+	position      time.Duration
+	paused        bool
+	at            time.Time
+	speed         float64
+	referenceLock sync.Mutex
+}
+
+func NewFollower(controls *Controls, corrector *party.DriftCorrector) *Follower {
+	return &Follower{
+		controls:  controls,
+		corrector: corrector,
+
+		paused: true,
+		speed:  1,
+	}
+}
+
+// SetReference records that the host was at `position` at `at` (party clock)
+func (f *Follower) SetReference(position time.Duration, paused bool, at time.Time) {
+	f.referenceLock.Lock()
+	defer f.referenceLock.Unlock()
+
+	f.position = position
+	f.paused = paused
+	f.at = at
+}
+
+// Sync corrects the drift between the local player at `position` and the host; `now` is the current party time
+func (f *Follower) Sync(position time.Duration, paused bool, now time.Time) error {
+	f.referenceLock.Lock()
+	defer f.referenceLock.Unlock()
+
+	if f.at.IsZero() || paused {
+		return nil
+	}
+
+	correction := f.corrector.Correct(position, f.corrector.Expected(f.position, f.paused, f.at, now), f.controls.SeekPrecision())
+
+	log.Trace().
+		Dur("drift", correction.Drift).
+		Float64("speed", correction.Speed).
+		Bool("seek", correction.Seek).
+		Msg("Correcting drift")
+
+	if correction.Seek {
+		log.Info().
+			Dur("drift", correction.Drift).
+			Msg("Seeking to correct drift")
+
+		if err := f.controls.Seek(correction.Position); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not seek to correct drift")
+		}
+	}
+
+	if correction.Speed != f.speed {
+		log.Debug().
+			Dur("drift", correction.Drift).
+			Float64("speed", correction.Speed).
+			Msg("Changing speed to correct drift")
+
+		if err := f.controls.SetSpeed(correction.Speed); err != nil {
+			return err
+		}
+
+		f.speed = correction.Speed
+	}
+
+	return nil
+}
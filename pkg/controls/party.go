@@ -0,0 +1,116 @@
+package controls
+
+import (
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/pojntfx/vintangle/pkg/player"
+	"github.com/rs/zerolog/log"
+)
+
+// SelectSubtitles shows the subtitles that a party member has selected; embedded tracks take precedence over files and an empty path disables subtitles
+func (c *Controls) SelectSubtitles(selectSubtitles v1.SelectSubtitles) error {
+	if selectSubtitles.Track != nil {
+		id, ok := c.findSubtitleTrack(*selectSubtitles.Track)
+		if !ok {
+			log.Warn().
+				Int("index", selectSubtitles.Track.Index).
+				Str("lang", selectSubtitles.Track.Lang).
+				Str("title", selectSubtitles.Track.Title).
+				Msg("Could not find embedded subtitle track, keeping the current subtitles")
+
+			return nil
+		}
+
+		return c.SetSubtitleTrack(id)
+	}
+
+	if selectSubtitles.Path == "" {
+		return c.DisableSubtitles()
+	}
+
+	return c.SetSubtitles(selectSubtitles.Path)
+}
+
+// ApplySnapshot brings the player to the host's state; `elapsed` is the time that has passed since the host took the snapshot
+func (c *Controls) ApplySnapshot(snapshot v1.Snapshot, elapsed time.Duration) error {
+	log.Info().
+		Str("subtitles", snapshot.Subtitles).
+		Float64("subtitleDelay", snapshot.SubtitleDelay).
+		Bool("paused", snapshot.Paused).
+		Float64("position", snapshot.Position).
+		Msg("Applying party snapshot")
+
+	if err := c.SelectSubtitles(v1.SelectSubtitles{
+		Path:  snapshot.Subtitles,
+		Track: snapshot.SubtitleTrack,
+	}); err != nil {
+		return err
+	}
+
+	if err := c.SetSubtitleDelay(time.Duration(snapshot.SubtitleDelay * float64(time.Second))); err != nil {
+		return err
+	}
+
+	position := time.Duration(snapshot.Position * float64(time.Second))
+	if !snapshot.Paused {
+		position += elapsed
+	}
+
+	if err := c.Seek(position); err != nil {
+		return err
+	}
+
+	return c.SetPaused(snapshot.Paused)
+}
+
+func (c *Controls) getEmbeddedSubtitleTracks() []player.Track {
+	tracks := []player.Track{}
+	for _, track := range c.State().Tracks {
+		if track.Type == player.TrackTypeSubtitles && !track.External {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return tracks
+}
+
+// SubtitleTrack returns the reference to the embedded subtitle track with the ID that the other members can resolve against their own tracks; it returns nil if there is no such track
+func (c *Controls) SubtitleTrack(id int) *v1.SubtitleTrack {
+	for i, track := range c.getEmbeddedSubtitleTracks() {
+		if track.ID == id {
+			return &v1.SubtitleTrack{
+				Index: i,
+				Lang:  track.Lang,
+				Title: track.Title,
+			}
+		}
+	}
+
+	return nil
+}
+
+// findSubtitleTrack returns the ID of the embedded subtitle track that the reference points to; since players don't always agree on the order of tracks, the language and title take precedence over the index
+func (c *Controls) findSubtitleTrack(reference v1.SubtitleTrack) (int, bool) {
+	tracks := c.getEmbeddedSubtitleTracks()
+
+	matches := func(track player.Track) bool {
+		return track.Lang == reference.Lang && track.Title == reference.Title
+	}
+
+	if reference.Index >= 0 && reference.Index < len(tracks) && matches(tracks[reference.Index]) {
+		return tracks[reference.Index].ID, true
+	}
+
+	for _, track := range tracks {
+		if matches(track) {
+			return track.ID, true
+		}
+	}
+
+	if reference.Index >= 0 && reference.Index < len(tracks) {
+		return tracks[reference.Index].ID, true
+	}
+
+	return 0, false
+}
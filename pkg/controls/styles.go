@@ -0,0 +1,127 @@
+package controls
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+)
+
+const (
+	maxSubtitleStyles = 500
+)
+
+// SubtitleStyle is how the subtitles of a media are shown
+type SubtitleStyle struct {
+	Delay     float64 `json:"delay"` // Delay in seconds
+	Scale     float64 `json:"scale"`
+	Position  int     `json:"position"` // Vertical position in percent of the screen height, where 100 is the bottom
+	Font      string  `json:"font"`     // Font family; empty uses the player's default
+	UpdatedAt int64   `json:"updatedAt"`
+}
+
+func DefaultSubtitleStyle() SubtitleStyle {
+	return SubtitleStyle{
+		Delay:    0,
+		Scale:    1,
+		Position: 100,
+		Font:     "",
+	}
+}
+
+// SubtitleStyles remembers the subtitle style of each media so that it can be reused the next time it is played
+type SubtitleStyles struct {
+	styles     map[string]SubtitleStyle
+	stylesLock sync.Mutex
+}
+
+func NewSubtitleStyles() *SubtitleStyles {
+	return &SubtitleStyles{
+		styles: map[string]SubtitleStyle{},
+	}
+}
+
+// getSubtitleStyleKey identifies a media by the info hash of the torrent since the trackers in magnet links can differ
+func getSubtitleStyleKey(magnet, path string) string {
+	torrent := magnet
+	if u, err := url.Parse(magnet); err == nil {
+		if xt := u.Query().Get("xt"); xt != "" {
+			torrent = strings.ToLower(xt)
+		}
+	}
+
+	return torrent + "/" + path
+}
+
+func (s *SubtitleStyles) Load(raw string) error {
+	s.stylesLock.Lock()
+	defer s.stylesLock.Unlock()
+
+	styles := map[string]SubtitleStyle{}
+	if strings.TrimSpace(raw) != "" {
+		if err := json.Unmarshal([]byte(raw), &styles); err != nil {
+			return err
+		}
+	}
+
+	s.styles = styles
+
+	return nil
+}
+
+// Save returns the styles to persist; only the most recently used ones are kept
+func (s *SubtitleStyles) Save() (string, error) {
+	s.stylesLock.Lock()
+	defer s.stylesLock.Unlock()
+
+	if len(s.styles) > maxSubtitleStyles {
+		keys := []string{}
+		for key := range s.styles {
+			keys = append(keys, key)
+		}
+
+		sort.Slice(keys, func(i, j int) bool {
+			return s.styles[keys[i]].UpdatedAt > s.styles[keys[j]].UpdatedAt
+		})
+
+		for _, key := range keys[maxSubtitleStyles:] {
+			delete(s.styles, key)
+		}
+	}
+
+	raw, err := json.Marshal(s.styles)
+	if err != nil {
+		return "", err
+	}
+
+	return string(raw), nil
+}
+
+// Get returns the style of the media; it returns the default style if the media has not been played before
+func (s *SubtitleStyles) Get(magnet, path string) SubtitleStyle {
+	s.stylesLock.Lock()
+	defer s.stylesLock.Unlock()
+
+	style, ok := s.styles[getSubtitleStyleKey(magnet, path)]
+	if !ok {
+		return DefaultSubtitleStyle()
+	}
+
+	return style
+}
+
+func (s *SubtitleStyles) Set(magnet, path string, style SubtitleStyle) {
+	s.stylesLock.Lock()
+	defer s.stylesLock.Unlock()
+
+	style.UpdatedAt = time.Now().Unix()
+
+	s.styles[getSubtitleStyleKey(magnet, path)] = style
+}
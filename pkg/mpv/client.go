@@ -0,0 +1,387 @@
+package mpv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	ErrClientNotOpen       = errors.New("mpv client is not open")
+	ErrClientClosed        = errors.New("mpv client has been closed")
+	ErrCommandFailed       = errors.New("mpv could not run command")
+	ErrPropertyUnavailable = errors.New("property unavailable")
+)
+
+const (
+	errorSuccess             = "success"
+	errorPropertyUnavailable = "property unavailable"
+)
+
+type request struct {
+	Command   interface{} `json:"command"`
+	RequestID int64       `json:"request_id"`
+}
+
+type response struct {
+	RequestID *int64              `json:"request_id"`
+	Error     string              `json:"error"`
+	Data      jsoniter.RawMessage `json:"data"`
+	Event     string              `json:"event"`
+	ID        int64               `json:"id"`
+	Name      string              `json:"name"`
+}
+
+// Event is an asynchronous message from mpv, i.e. a `property-change` event for an observed property
+type Event struct {
+	Event string
+	ID    int64 // ID of the observer for `property-change` events
+	Name  string
+	Data  jsoniter.RawMessage
+}
+
+// Client talks to mpv over its JSON IPC socket; see https://mpv.io/manual/stable/#json-ipc
+type Client struct {
+	raddr   string
+	timeout time.Duration
+
+	conn     net.Conn
+	connLock sync.Mutex
+
+	nextRequestID int64
+	pending       map[int64]chan response
+	pendingLock   sync.Mutex
+
+	nextObserverID    int64
+	eventHandlers     []func(Event)
+	eventHandlersLock sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	errs chan error
+
+	ctx context.Context
+}
+
+func NewClient(
+	raddr string,
+	timeout time.Duration,
+
+	ctx context.Context,
+) *Client {
+	return &Client{
+		raddr:   raddr,
+		timeout: timeout,
+
+		pending: map[int64]chan response{},
+
+		done: make(chan struct{}),
+
+		errs: make(chan error, 1),
+
+		ctx: ctx,
+	}
+}
+
+func (c *Client) Open() error {
+	log.Trace().Msg("Opening mpv client")
+
+	var d net.Dialer
+	conn, err := d.DialContext(c.ctx, "unix", c.raddr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			var res response
+			if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not parse mpv message, skipping")
+
+				continue
+			}
+
+			if res.Event != "" {
+				log.Trace().
+					Str("event", res.Event).
+					Str("name", res.Name).
+					Msg("Received mpv event")
+
+				c.eventHandlersLock.Lock()
+				handlers := append([]func(Event){}, c.eventHandlers...)
+				c.eventHandlersLock.Unlock()
+
+				for _, handler := range handlers {
+					handler(Event{
+						Event: res.Event,
+						ID:    res.ID,
+						Name:  res.Name,
+						Data:  res.Data,
+					})
+				}
+
+				continue
+			}
+
+			if res.RequestID == nil {
+				continue
+			}
+
+			c.pendingLock.Lock()
+			responses, ok := c.pending[*res.RequestID]
+			delete(c.pending, *res.RequestID)
+			c.pendingLock.Unlock()
+
+			if ok {
+				responses <- res
+			}
+		}
+
+		c.closeOnce.Do(func() {
+			close(c.done)
+		})
+
+		if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.errs <- err
+
+			return
+		}
+
+		c.errs <- nil
+	}()
+
+	return nil
+}
+
+// Command runs a command with positional arguments and returns its result
+func (c *Client) Command(ctx context.Context, args ...interface{}) (jsoniter.RawMessage, error) {
+	return c.request(ctx, args)
+}
+
+// NamedCommand runs a command with named arguments, which some commands such as `osd-overlay` require
+func (c *Client) NamedCommand(ctx context.Context, name string, args map[string]interface{}) (jsoniter.RawMessage, error) {
+	command := map[string]interface{}{
+		"name": name,
+	}
+	for key, value := range args {
+		command[key] = value
+	}
+
+	return c.request(ctx, command)
+}
+
+func (c *Client) request(ctx context.Context, command interface{}) (jsoniter.RawMessage, error) {
+	if c.conn == nil {
+		return nil, ErrClientNotOpen
+	}
+
+	select {
+	case <-c.done:
+		return nil, ErrClientClosed
+	default:
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	responses := make(chan response, 1)
+
+	c.pendingLock.Lock()
+	c.nextRequestID++
+	requestID := c.nextRequestID
+	c.pending[requestID] = responses
+	c.pendingLock.Unlock()
+
+	defer func() {
+		c.pendingLock.Lock()
+		delete(c.pending, requestID)
+		c.pendingLock.Unlock()
+	}()
+
+	raw, err := json.Marshal(request{
+		Command:   command,
+		RequestID: requestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.connLock.Lock()
+	_, err = c.conn.Write(append(raw, '\n'))
+	c.connLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-responses:
+		switch res.Error {
+		case errorSuccess:
+			return res.Data, nil
+		case errorPropertyUnavailable:
+			return nil, ErrPropertyUnavailable
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrCommandFailed, res.Error)
+		}
+	case <-c.done:
+		return nil, ErrClientClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// HandleEvents calls the handler for every event; it is called from the reader goroutine, so it must not block or send commands itself
+func (c *Client) HandleEvents(handler func(Event)) {
+	c.eventHandlersLock.Lock()
+	defer c.eventHandlersLock.Unlock()
+
+	c.eventHandlers = append(c.eventHandlers, handler)
+}
+
+// ObserveProperty subscribes to `property-change` events for the property and returns the ID of the observer
+func (c *Client) ObserveProperty(ctx context.Context, name string) (int64, error) {
+	c.pendingLock.Lock()
+	c.nextObserverID++
+	id := c.nextObserverID
+	c.pendingLock.Unlock()
+
+	if _, err := c.Command(ctx, "observe_property", id, name); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (c *Client) SetProperty(ctx context.Context, name string, value interface{}) error {
+	_, err := c.Command(ctx, "set_property", name, value)
+
+	return err
+}
+
+// GetProperty returns the value of the property; it is a function since methods can't have type parameters
+func GetProperty[T any](ctx context.Context, c *Client, name string) (T, error) {
+	var value T
+
+	raw, err := c.Command(ctx, "get_property", name)
+	if err != nil {
+		return value, err
+	}
+
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return value, err
+	}
+
+	return value, nil
+}
+
+func (c *Client) Pause(ctx context.Context, paused bool) error {
+	return c.SetProperty(ctx, "pause", paused)
+}
+
+// Seek jumps to the absolute position in seconds
+func (c *Client) Seek(ctx context.Context, position float64) error {
+	_, err := c.Command(ctx, "seek", position, "absolute")
+
+	return err
+}
+
+// SetVolume sets the volume in percent
+func (c *Client) SetVolume(ctx context.Context, volume float64) error {
+	return c.SetProperty(ctx, "volume", volume)
+}
+
+func (c *Client) SetSpeed(ctx context.Context, speed float64) error {
+	return c.SetProperty(ctx, "speed", speed)
+}
+
+func (c *Client) SetFullscreen(ctx context.Context, fullscreen bool) error {
+	return c.SetProperty(ctx, "fullscreen", fullscreen)
+}
+
+// SetSubFile replaces the external subtitles with the file at the path
+func (c *Client) SetSubFile(ctx context.Context, path string) error {
+	_, err := c.Command(ctx, "change-list", "sub-files", "set", path)
+
+	return err
+}
+
+// AddSubFile adds the external subtitles at the path and selects them
+func (c *Client) AddSubFile(ctx context.Context, path string) error {
+	_, err := c.Command(ctx, "sub-add", path, "select")
+
+	return err
+}
+
+// ClearSubFiles removes all external subtitles
+func (c *Client) ClearSubFiles(ctx context.Context) error {
+	_, err := c.Command(ctx, "change-list", "sub-files", "clr")
+
+	return err
+}
+
+// SetSubDelay sets the subtitle delay in seconds
+func (c *Client) SetSubDelay(ctx context.Context, delay float64) error {
+	return c.SetProperty(ctx, "sub-delay", delay)
+}
+
+// ShowText shows the text on the OSD for the given duration
+func (c *Client) ShowText(ctx context.Context, text string, duration time.Duration) error {
+	_, err := c.Command(ctx, "show-text", text, duration.Milliseconds())
+
+	return err
+}
+
+// SetOverlay shows ASS events on the OSD in the overlay with the given ID
+func (c *Client) SetOverlay(ctx context.Context, id int, data string) error {
+	_, err := c.NamedCommand(ctx, "osd-overlay", map[string]interface{}{
+		"id":     id,
+		"format": "ass-events",
+		"data":   data,
+	})
+
+	return err
+}
+
+// RemoveOverlay hides the overlay with the given ID
+func (c *Client) RemoveOverlay(ctx context.Context, id int) error {
+	_, err := c.NamedCommand(ctx, "osd-overlay", map[string]interface{}{
+		"id":     id,
+		"format": "none",
+		"data":   "",
+	})
+
+	return err
+}
+
+func (c *Client) Wait() error {
+	return <-c.errs
+}
+
+func (c *Client) Close() error {
+	log.Trace().Msg("Closing mpv client")
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
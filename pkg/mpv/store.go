@@ -0,0 +1,194 @@
+package mpv
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	eventPropertyChange = "property-change"
+)
+
+// Track is an entry of mpv's `track-list` property
+type Track struct {
+	ID               int    `json:"id"`
+	Type             string `json:"type"` // One of `video`, `audio` or `sub`
+	Title            string `json:"title"`
+	Lang             string `json:"lang"`
+	Codec            string `json:"codec"`
+	Selected         bool   `json:"selected"`
+	Default          bool   `json:"default"`
+	Forced           bool   `json:"forced"`
+	External         bool   `json:"external"`
+	ExternalFilename string `json:"external-filename"`
+}
+
+// State is the playback state of mpv; properties that are unavailable, i.e. before the media has loaded, are zero
+type State struct {
+	TimePos          float64
+	Duration         float64
+	Pause            bool
+	Volume           float64
+	EOFReached       bool
+	PausedForCache   bool
+	DemuxerCacheTime float64
+	SubDelay         float64
+	TrackList        []Track
+}
+
+// Store keeps the state of mpv up to date by observing its properties and notifies subscribers of changes
+type Store struct {
+	client *Client
+
+	state     State
+	stateLock sync.Mutex
+
+	subscribers     []func(previous, current State)
+	subscribersLock sync.Mutex
+
+	changed chan struct{}
+
+	ctx context.Context
+}
+
+func NewStore(
+	client *Client,
+
+	ctx context.Context,
+) *Store {
+	return &Store{
+		client: client,
+
+		changed: make(chan struct{}, 1),
+
+		ctx: ctx,
+	}
+}
+
+func (s *Store) Open() error {
+	log.Trace().Msg("Opening mpv store")
+
+	properties := map[string]func(data []byte) error{
+		"time-pos": func(data []byte) error {
+			return decodeProperty(data, &s.state.TimePos)
+		},
+		"duration": func(data []byte) error {
+			return decodeProperty(data, &s.state.Duration)
+		},
+		"pause": func(data []byte) error {
+			return decodeProperty(data, &s.state.Pause)
+		},
+		"volume": func(data []byte) error {
+			return decodeProperty(data, &s.state.Volume)
+		},
+		"eof-reached": func(data []byte) error {
+			return decodeProperty(data, &s.state.EOFReached)
+		},
+		"paused-for-cache": func(data []byte) error {
+			return decodeProperty(data, &s.state.PausedForCache)
+		},
+		"demuxer-cache-time": func(data []byte) error {
+			return decodeProperty(data, &s.state.DemuxerCacheTime)
+		},
+		"sub-delay": func(data []byte) error {
+			return decodeProperty(data, &s.state.SubDelay)
+		},
+		"track-list": func(data []byte) error {
+			return decodeProperty(data, &s.state.TrackList)
+		},
+	}
+
+	// Events are handled in the client's reader goroutine, so we only update the state here and notify subscribers from our own goroutine
+	s.client.HandleEvents(func(event Event) {
+		if event.Event != eventPropertyChange {
+			return
+		}
+
+		update, ok := properties[event.Name]
+		if !ok {
+			return
+		}
+
+		s.stateLock.Lock()
+		err := update(event.Data)
+		s.stateLock.Unlock()
+
+		if err != nil {
+			log.Debug().
+				Str("name", event.Name).
+				Err(err).
+				Msg("Could not parse property, skipping")
+
+			return
+		}
+
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	})
+
+	go func() {
+		previous := State{}
+		for {
+			select {
+			case <-s.changed:
+				current := s.Get()
+
+				s.subscribersLock.Lock()
+				subscribers := append([]func(previous, current State){}, s.subscribers...)
+				s.subscribersLock.Unlock()
+
+				for _, subscriber := range subscribers {
+					subscriber(previous, current)
+				}
+
+				previous = current
+			case <-s.client.done:
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for name := range properties {
+		if _, err := s.client.ObserveProperty(s.ctx, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) Get() State {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	state := s.state
+	state.TrackList = append([]Track{}, s.state.TrackList...)
+
+	return state
+}
+
+// Subscribe calls the subscriber whenever the state has changed; bursts of changes are coalesced
+func (s *Store) Subscribe(subscriber func(previous, current State)) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+
+	s.subscribers = append(s.subscribers, subscriber)
+}
+
+// decodeProperty resets the value to zero if the property is unavailable
+func decodeProperty[T any](data []byte, value *T) error {
+	var zero T
+	*value = zero
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,484 @@
+package mpvtest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	ErrServerNotOpen = errors.New("fake mpv server is not open")
+)
+
+var (
+	trackProperties = map[string]string{
+		"vid": "video",
+		"aid": "audio",
+		"sid": "sub",
+	}
+)
+
+const (
+	errorSuccess             = "success"
+	errorPropertyUnavailable = "property unavailable"
+	errorInvalidParameter    = "invalid parameter"
+)
+
+// Command is a command that the fake mpv has received; named commands have their arguments in NamedArgs
+type Command struct {
+	Name      string
+	Args      []interface{}
+	NamedArgs map[string]interface{}
+}
+
+type request struct {
+	Command   jsoniter.RawMessage `json:"command"`
+	RequestID int64               `json:"request_id"`
+}
+
+type response struct {
+	RequestID int64       `json:"request_id"`
+	Error     string      `json:"error"`
+	Data      interface{} `json:"data"`
+}
+
+type event struct {
+	Event string      `json:"event"`
+	ID    int64       `json:"id"`
+	Name  string      `json:"name"`
+	Data  interface{} `json:"data"`
+}
+
+type observer struct {
+	id   int64
+	name string
+	conn *conn
+}
+
+type conn struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+}
+
+func (c *conn) write(v interface{}) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, _ = c.conn.Write(append(raw, '\n'))
+}
+
+// Server is an in-process fake of mpv's JSON IPC server; it keeps track of properties and records commands so that
+// the controls can be driven without mpv or a display
+type Server struct {
+	laddr string
+
+	listener net.Listener
+
+	properties map[string]interface{}
+	commands   []Command
+	observers  []observer
+	stateLock  sync.Mutex
+
+	errs chan error
+
+	ctx context.Context
+}
+
+func NewServer(
+	laddr string,
+
+	ctx context.Context,
+) *Server {
+	return &Server{
+		laddr: laddr,
+
+		// mpv is started with `--pause`; `time-pos` and `duration` are unavailable until the media has loaded
+		properties: map[string]interface{}{
+			"pause":            true,
+			"volume":           float64(100),
+			"speed":            float64(1),
+			"fullscreen":       false,
+			"sub-files":        []interface{}{},
+			"sub-delay":        float64(0),
+			"sub-scale":        float64(1),
+			"sub-pos":          float64(100),
+			"sub-font":         "sans-serif",
+			"eof-reached":      false,
+			"paused-for-cache": false,
+			"track-list":       []interface{}{},
+		},
+		commands: []Command{},
+
+		errs: make(chan error, 1),
+
+		ctx: ctx,
+	}
+}
+
+func (s *Server) Open() error {
+	log.Trace().Msg("Opening fake mpv server")
+
+	listener, err := net.Listen("unix", s.laddr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					s.errs <- nil
+
+					return
+				}
+
+				s.errs <- err
+
+				return
+			}
+
+			go s.handleConn(&conn{conn: c})
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) handleConn(c *conn) {
+	defer c.conn.Close()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Could not parse request, skipping")
+
+			continue
+		}
+
+		command := Command{}
+
+		var args []interface{}
+		if err := json.Unmarshal(req.Command, &args); err == nil && len(args) > 0 {
+			name, ok := args[0].(string)
+			if !ok {
+				c.write(response{RequestID: req.RequestID, Error: errorInvalidParameter})
+
+				continue
+			}
+
+			command.Name = name
+			command.Args = args[1:]
+		} else {
+			namedArgs := map[string]interface{}{}
+			if err := json.Unmarshal(req.Command, &namedArgs); err != nil {
+				c.write(response{RequestID: req.RequestID, Error: errorInvalidParameter})
+
+				continue
+			}
+
+			name, ok := namedArgs["name"].(string)
+			if !ok {
+				c.write(response{RequestID: req.RequestID, Error: errorInvalidParameter})
+
+				continue
+			}
+			delete(namedArgs, "name")
+
+			command.Name = name
+			command.NamedArgs = namedArgs
+		}
+
+		data, err := s.handleCommand(c, command)
+		if err != "" {
+			c.write(response{RequestID: req.RequestID, Error: err})
+
+			continue
+		}
+
+		c.write(response{RequestID: req.RequestID, Error: errorSuccess, Data: data})
+	}
+}
+
+// handleCommand runs the command and returns its result or the mpv error string
+func (s *Server) handleCommand(c *conn, command Command) (interface{}, string) {
+	s.stateLock.Lock()
+	s.commands = append(s.commands, command)
+	s.stateLock.Unlock()
+
+	switch command.Name {
+	case "get_property":
+		name, ok := stringArg(command.Args, 0)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		value, ok := s.Property(name)
+		if !ok {
+			return nil, errorPropertyUnavailable
+		}
+
+		return value, ""
+
+	case "set_property":
+		name, ok := stringArg(command.Args, 0)
+		if !ok || len(command.Args) < 2 {
+			return nil, errorInvalidParameter
+		}
+
+		s.SetProperty(name, command.Args[1])
+
+		if trackType, ok := trackProperties[name]; ok {
+			s.selectTrack(trackType, command.Args[1])
+		}
+
+		return nil, ""
+
+	case "observe_property":
+		id, ok := numberArg(command.Args, 0)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		name, ok := stringArg(command.Args, 1)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		s.stateLock.Lock()
+		s.observers = append(s.observers, observer{
+			id:   int64(id),
+			name: name,
+			conn: c,
+		})
+		value := s.properties[name]
+		s.stateLock.Unlock()
+
+		// Like mpv, send the current value when subscribing
+		c.write(event{
+			Event: "property-change",
+			ID:    int64(id),
+			Name:  name,
+			Data:  value,
+		})
+
+		return nil, ""
+
+	case "seek":
+		target, ok := numberArg(command.Args, 0)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		flags, _ := stringArg(command.Args, 1)
+		if flags != "absolute" {
+			position, _ := s.Property("time-pos")
+			current, _ := position.(float64)
+
+			target += current
+		}
+
+		if target < 0 {
+			target = 0
+		}
+
+		if duration, ok := s.Property("duration"); ok {
+			if duration, ok := duration.(float64); ok && target > duration {
+				target = duration
+			}
+		}
+
+		s.SetProperty("time-pos", target)
+
+		return nil, ""
+
+	case "change-list":
+		name, ok := stringArg(command.Args, 0)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		operation, ok := stringArg(command.Args, 1)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		// Every operation except for clearing the list needs a value
+		if operation != "clr" && len(command.Args) < 3 {
+			return nil, errorInvalidParameter
+		}
+
+		value, _ := s.Property(name)
+		list, _ := value.([]interface{})
+
+		switch operation {
+		case "set":
+			list = []interface{}{command.Args[2]}
+		case "append", "add":
+			list = append(append([]interface{}{}, list...), command.Args[2])
+		case "clr":
+			list = []interface{}{}
+		default:
+			return nil, errorInvalidParameter
+		}
+
+		s.SetProperty(name, list)
+
+		return nil, ""
+
+	case "sub-add":
+		path, ok := stringArg(command.Args, 0)
+		if !ok {
+			return nil, errorInvalidParameter
+		}
+
+		value, _ := s.Property("sub-files")
+		list, _ := value.([]interface{})
+
+		s.SetProperty("sub-files", append(append([]interface{}{}, list...), path))
+
+		return nil, ""
+
+	case "show-text", "osd-overlay", "quit":
+		return nil, ""
+	}
+
+	return nil, errorInvalidParameter
+}
+
+// selectTrack marks the track of the type with the ID as selected in the `track-list` property; other IDs such as `no` deselect all tracks of the type
+func (s *Server) selectTrack(trackType string, id interface{}) {
+	value, _ := s.Property("track-list")
+	tracks, _ := value.([]interface{})
+
+	newTracks := []interface{}{}
+	for _, rawTrack := range tracks {
+		track, ok := rawTrack.(map[string]interface{})
+		if !ok {
+			newTracks = append(newTracks, rawTrack)
+
+			continue
+		}
+
+		if track["type"] != trackType {
+			newTracks = append(newTracks, track)
+
+			continue
+		}
+
+		newTrack := map[string]interface{}{}
+		for key, value := range track {
+			newTrack[key] = value
+		}
+		// IDs can be numbers of any type if the track list has been set by a test
+		newTrack["selected"] = fmt.Sprint(track["id"]) == fmt.Sprint(id)
+
+		newTracks = append(newTracks, newTrack)
+	}
+
+	s.SetProperty("track-list", newTracks)
+}
+
+// Property returns the value of the property; it returns false if the property is unavailable
+func (s *Server) Property(name string) (interface{}, bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	value, ok := s.properties[name]
+
+	return value, ok
+}
+
+// SetProperty changes the property and notifies observers, i.e. to simulate playback progressing or the media loading
+func (s *Server) SetProperty(name string, value interface{}) {
+	s.stateLock.Lock()
+	s.properties[name] = value
+
+	observers := []observer{}
+	for _, o := range s.observers {
+		if o.name == name {
+			observers = append(observers, o)
+		}
+	}
+	s.stateLock.Unlock()
+
+	for _, o := range observers {
+		o.conn.write(event{
+			Event: "property-change",
+			ID:    o.id,
+			Name:  name,
+			Data:  value,
+		})
+	}
+}
+
+// Commands returns all commands that the server has received so far
+func (s *Server) Commands() []Command {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return append([]Command{}, s.commands...)
+}
+
+func (s *Server) Wait() error {
+	return <-s.errs
+}
+
+func (s *Server) Close() error {
+	log.Trace().Msg("Closing fake mpv server")
+
+	if s.listener == nil {
+		return ErrServerNotOpen
+	}
+
+	return s.listener.Close()
+}
+
+func stringArg(args []interface{}, i int) (string, bool) {
+	if i >= len(args) {
+		return "", false
+	}
+
+	value, ok := args[i].(string)
+
+	return value, ok
+}
+
+func numberArg(args []interface{}, i int) (float64, bool) {
+	if i >= len(args) {
+		return 0, false
+	}
+
+	switch value := args[i].(type) {
+	case float64:
+		return value, true
+	case string:
+		var number float64
+		if _, err := fmt.Sscanf(value, "%g", &number); err != nil {
+			return 0, false
+		}
+
+		return number, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package mpvtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pojntfx/vintangle/pkg/mpv"
+)
+
+func TestServerChangeList(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ipcDir, err := os.MkdirTemp("", "mpvtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ipcDir)
+
+	ipcFile := filepath.Join(ipcDir, "mpv.sock")
+
+	server := NewServer(ipcFile, ctx)
+	if err := server.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client := mpv.NewClient(ipcFile, time.Second*5, ctx)
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name     string
+		args     []interface{}
+		valid    bool
+		expected []interface{}
+	}{
+		{"set", []interface{}{"sub-files", "set", "a.srt"}, true, []interface{}{"a.srt"}},
+		{"append", []interface{}{"sub-files", "append", "b.srt"}, true, []interface{}{"a.srt", "b.srt"}},
+		{"set without value", []interface{}{"sub-files", "set"}, false, []interface{}{"a.srt", "b.srt"}},
+		{"append without value", []interface{}{"sub-files", "append"}, false, []interface{}{"a.srt", "b.srt"}},
+		{"unknown operation", []interface{}{"sub-files", "remove", "a.srt"}, false, []interface{}{"a.srt", "b.srt"}},
+		{"clear", []interface{}{"sub-files", "clr"}, true, []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Command(ctx, append([]interface{}{"change-list"}, tt.args...)...)
+			if tt.valid && err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatal("accepted invalid command")
+			}
+
+			value, _ := server.Property("sub-files")
+			files, _ := value.([]interface{})
+			if len(files) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, files)
+			}
+
+			for i := range files {
+				if files[i] != tt.expected[i] {
+					t.Fatalf("expected %v, got %v", tt.expected, files)
+				}
+			}
+		})
+	}
+}
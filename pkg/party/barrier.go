@@ -0,0 +1,49 @@
+package party
+
+import (
+	"sort"
+	"sync"
+)
+
+// Barrier tracks which party members are ready to play
+type Barrier struct {
+	members     map[string]bool
+	membersLock sync.Mutex
+}
+
+func NewBarrier() *Barrier {
+	return &Barrier{
+		members: map[string]bool{},
+	}
+}
+
+func (b *Barrier) Set(member string, ready bool) {
+	b.membersLock.Lock()
+	defer b.membersLock.Unlock()
+
+	b.members[member] = ready
+}
+
+func (b *Barrier) Remove(member string) {
+	b.membersLock.Lock()
+	defer b.membersLock.Unlock()
+
+	delete(b.members, member)
+}
+
+// Waiting returns the sorted IDs of all members that are not ready yet
+func (b *Barrier) Waiting() []string {
+	b.membersLock.Lock()
+	defer b.membersLock.Unlock()
+
+	waiting := []string{}
+	for member, ready := range b.members {
+		if !ready {
+			waiting = append(waiting, member)
+		}
+	}
+
+	sort.Strings(waiting)
+
+	return waiting
+}
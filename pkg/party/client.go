@@ -0,0 +1,775 @@
+package party
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	ErrEmptyRoom          = errors.New("could not work with empty room")
+	ErrPartyNotOpen       = errors.New("could not send to party that is not open")
+	ErrUnsupportedVersion = errors.New("unsupported party protocol version")
+	ErrNotReference       = errors.New("only the reference member can rotate keys")
+	ErrUnknownMember      = errors.New("could not find public key for member")
+)
+
+const (
+	clockBurstSamples  = 5
+	clockBurstInterval = time.Millisecond * 200
+
+	reconnectMinBackoff = time.Millisecond * 500
+	reconnectMaxBackoff = time.Second * 30
+
+	replayWindow = time.Minute * 10
+)
+
+type Client struct {
+	newTransport      func() Transport
+	room              string
+	keyring           *Keyring
+	id                string
+	name              string
+	reference         string
+	resumeToken       string
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	clockSyncInterval time.Duration
+	clockSamples      int
+
+	onMessage          func(msg v1.Message)
+	onAdmissionRequest func(id, name string)
+	onConnectionChange func(connected bool)
+
+	opened   bool
+	sendLock sync.Mutex
+
+	transport     Transport
+	lastReceived  time.Time
+	transportLock sync.Mutex
+
+	clock   *Clock
+	replays *ReplayGuard
+
+	publicKeys map[string][]byte
+	// Members that have been announced since reconnecting; nil unless we're catching up after a reconnect
+	rejoined       map[string]struct{}
+	rejoinedAt     time.Time
+	publicKeysLock sync.Mutex
+
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	ctx context.Context
+}
+
+func NewClient(
+	newTransport func() Transport,
+	room string,
+	keyring *Keyring,
+	id string,
+	name string,
+	reference string,
+	heartbeatInterval time.Duration,
+	heartbeatTimeout time.Duration,
+	clockSyncInterval time.Duration,
+	clockSamples int,
+
+	onMessage func(msg v1.Message),
+	onAdmissionRequest func(id, name string),
+	onConnectionChange func(connected bool),
+
+	ctx context.Context,
+) *Client {
+	return &Client{
+		newTransport:      newTransport,
+		room:              room,
+		keyring:           keyring,
+		id:                id,
+		name:              name,
+		reference:         reference,
+		heartbeatInterval: heartbeatInterval,
+		heartbeatTimeout:  heartbeatTimeout,
+		clockSyncInterval: clockSyncInterval,
+		clockSamples:      clockSamples,
+
+		onMessage:          onMessage,
+		onAdmissionRequest: onAdmissionRequest,
+		onConnectionChange: onConnectionChange,
+
+		clock:   NewClock(clockSamples, time.Now),
+		replays: NewReplayGuard(replayWindow),
+
+		publicKeys: map[string][]byte{},
+
+		errs: make(chan error, 1),
+		done: make(chan struct{}),
+
+		ctx: ctx,
+	}
+}
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Clock returns the party clock, which follows the clock of the reference member
+func (c *Client) Clock() *Clock {
+	return c.clock
+}
+
+func (c *Client) Open() error {
+	log.Trace().Msg("Opening party client")
+
+	if c.room == "" {
+		return ErrEmptyRoom
+	}
+
+	resumeToken, err := newResumeToken()
+	if err != nil {
+		return err
+	}
+	c.resumeToken = resumeToken
+
+	transport, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := c.receive(transport)
+
+			select {
+			case <-c.done:
+				c.errs <- nil
+
+				return
+			default:
+			}
+
+			log.Warn().
+				Err(err).
+				Msg("Lost connection to party, reconnecting")
+
+			c.onConnectionChange(false)
+
+			transport, err = c.reconnect()
+			if err != nil {
+				c.errs <- err
+
+				return
+			}
+
+			if transport == nil {
+				c.errs <- nil
+
+				return
+			}
+
+			log.Info().Msg("Reconnected to party")
+
+			c.onConnectionChange(true)
+		}
+	}()
+
+	go func() {
+		t := time.NewTicker(c.heartbeatInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				c.transportLock.Lock()
+				transport, lastReceived := c.transport, c.lastReceived
+				c.transportLock.Unlock()
+
+				// The relay acknowledges heartbeats, so not hearing anything means that the connection is gone
+				if time.Since(lastReceived) > c.heartbeatTimeout {
+					log.Debug().
+						Time("lastReceived", lastReceived).
+						Msg("Party connection timed out, closing it")
+
+					if err := transport.Close(); err != nil {
+						log.Debug().
+							Err(err).
+							Msg("Could not close timed out transport")
+					}
+
+					continue
+				}
+
+				if err := c.Send(v1.TypeHeartbeat, v1.Heartbeat{}); err != nil {
+					log.Debug().
+						Err(err).
+						Msg("Could not send heartbeat")
+				}
+			case <-c.done:
+				return
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if c.id != c.reference {
+		go c.syncClock()
+	}
+
+	return nil
+}
+
+// connect opens a new transport and announces us to the party
+func (c *Client) connect() (Transport, error) {
+	transport := c.newTransport()
+	if err := transport.Open(); err != nil {
+		return nil, err
+	}
+
+	c.transportLock.Lock()
+	c.transport = transport
+	c.lastReceived = time.Now()
+	c.opened = true
+	c.transportLock.Unlock()
+
+	if err := c.Send(v1.TypeJoin, v1.Join{
+		Name:      c.name,
+		PublicKey: c.keyring.PublicKey(),
+	}); err != nil {
+		_ = transport.Close()
+
+		return nil, err
+	}
+
+	return transport, nil
+}
+
+// reconnect retries connecting with exponential backoff; it returns a nil transport if the client has been closed in the meantime
+func (c *Client) reconnect() (Transport, error) {
+	backoff := reconnectMinBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-c.done:
+			return nil, nil
+		case <-c.ctx.Done():
+			return nil, c.ctx.Err()
+		}
+
+		c.publicKeysLock.Lock()
+		c.rejoined = map[string]struct{}{}
+		c.rejoinedAt = time.Now()
+		c.publicKeysLock.Unlock()
+
+		transport, err := c.connect()
+		if err == nil {
+			return transport, nil
+		}
+
+		log.Debug().
+			Err(err).
+			Dur("backoff", backoff).
+			Msg("Could not reconnect to party, retrying")
+
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+func (c *Client) receive(transport Transport) error {
+	for {
+		frame, err := transport.Receive()
+		if err != nil {
+			return err
+		}
+
+		received := c.clock.Local()
+
+		c.transportLock.Lock()
+		c.lastReceived = time.Now()
+		c.transportLock.Unlock()
+
+		c.pruneMembers()
+
+		var msg v1.Message
+		if err := json.Unmarshal(frame, &msg); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not parse party message, skipping")
+
+			continue
+		}
+
+		if msg.Version != v1.Version {
+			log.Warn().
+				Int("version", msg.Version).
+				Err(ErrUnsupportedVersion).
+				Msg("Could not handle party message, skipping")
+
+			continue
+		}
+
+		if msg.From == c.id {
+			continue
+		}
+
+		if err := c.open(&msg); err != nil {
+			log.Debug().
+				Str("type", msg.Type).
+				Str("from", msg.From).
+				Err(err).
+				Msg("Could not open party message, skipping")
+
+			continue
+		}
+
+		switch msg.Type {
+		case v1.TypeJoin:
+			if err := c.handleJoin(msg); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not handle join, skipping")
+
+				continue
+			}
+		case v1.TypeLeave:
+			c.publicKeysLock.Lock()
+			delete(c.publicKeys, msg.From)
+			c.publicKeysLock.Unlock()
+		case v1.TypeRekey:
+			if err := c.handleRekey(msg); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not handle rekey, skipping")
+			}
+
+			continue
+		case v1.TypeClockRequest:
+			if err := c.handleClockRequest(msg, received); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not answer clock request, skipping")
+			}
+
+			continue
+		case v1.TypeClockResponse:
+			if err := c.handleClockResponse(msg, received); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not handle clock response, skipping")
+			}
+
+			continue
+		}
+
+		log.Debug().
+			Str("type", msg.Type).
+			Str("from", msg.From).
+			Msg("Received party message")
+
+		c.onMessage(msg)
+	}
+}
+
+// pruneMembers synthesizes leaves for the members that left while we were disconnected, which are the ones the relay didn't announce again after reconnecting
+func (c *Client) pruneMembers() {
+	c.publicKeysLock.Lock()
+	if c.rejoined == nil || time.Since(c.rejoinedAt) < c.heartbeatInterval {
+		c.publicKeysLock.Unlock()
+
+		return
+	}
+
+	gone := []string{}
+	for member := range c.publicKeys {
+		if _, ok := c.rejoined[member]; !ok {
+			gone = append(gone, member)
+
+			delete(c.publicKeys, member)
+		}
+	}
+	c.rejoined = nil
+	c.publicKeysLock.Unlock()
+
+	for _, member := range gone {
+		log.Debug().
+			Str("member", member).
+			Msg("Member left while we were disconnected")
+
+		c.onMessage(v1.Message{
+			Version:   v1.Version,
+			Type:      v1.TypeLeave,
+			Room:      c.room,
+			From:      member,
+			Timestamp: c.clock.Now().UnixNano(),
+			Payload:   []byte("{}"),
+		})
+	}
+}
+
+func (c *Client) syncClock() {
+	for i := 0; i < clockBurstSamples; i++ {
+		if err := c.requestClock(); err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Could not request clock")
+		}
+
+		select {
+		case <-time.After(clockBurstInterval):
+		case <-c.done:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+
+	t := time.NewTicker(c.clockSyncInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := c.requestClock(); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not request clock")
+			}
+		case <-c.done:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Client) requestClock() error {
+	return c.Send(v1.TypeClockRequest, v1.ClockRequest{
+		Origin: c.clock.Local().UnixNano(),
+	})
+}
+
+func (c *Client) handleClockRequest(msg v1.Message, received time.Time) error {
+	// Only the reference member's clock is authoritative
+	if c.id != c.reference {
+		return nil
+	}
+
+	var request v1.ClockRequest
+	if err := json.Unmarshal(msg.Payload, &request); err != nil {
+		return err
+	}
+
+	return c.Send(v1.TypeClockResponse, v1.ClockResponse{
+		To:       msg.From,
+		Origin:   request.Origin,
+		Receive:  received.Add(c.clock.Offset()).UnixNano(),
+		Transmit: c.clock.Now().UnixNano(),
+	})
+}
+
+func (c *Client) handleClockResponse(msg v1.Message, received time.Time) error {
+	var response v1.ClockResponse
+	if err := json.Unmarshal(msg.Payload, &response); err != nil {
+		return err
+	}
+
+	if response.To != c.id || msg.From != c.reference {
+		return nil
+	}
+
+	sample, ok := c.clock.AddSample(
+		time.Unix(0, response.Origin),
+		time.Unix(0, response.Receive),
+		time.Unix(0, response.Transmit),
+		received,
+	)
+	if !ok {
+		return nil
+	}
+
+	log.Trace().
+		Dur("offset", sample.Offset).
+		Dur("rtt", sample.RTT).
+		Dur("estimatedOffset", c.clock.Offset()).
+		Dur("estimatedRTT", c.clock.RTT()).
+		Msg("Synchronized party clock")
+
+	return nil
+}
+
+func (c *Client) handleJoin(msg v1.Message) error {
+	var join v1.Join
+	if err := json.Unmarshal(msg.Payload, &join); err != nil {
+		return err
+	}
+
+	if c.keyring.Excluded(msg.From) {
+		return nil
+	}
+
+	c.publicKeysLock.Lock()
+	if c.rejoined != nil {
+		c.rejoined[msg.From] = struct{}{}
+	}
+
+	// Keep the first key we've seen so that members can't be impersonated by a later join
+	if _, ok := c.publicKeys[msg.From]; !ok {
+		c.publicKeys[msg.From] = join.PublicKey
+	}
+	c.publicKeysLock.Unlock()
+
+	if c.id != c.reference {
+		return nil
+	}
+
+	// Everyone with the invite can read the key derived from it, so there is nothing to hand out yet
+	if c.keyring.Epoch() == 0 {
+		c.keyring.Admit(msg.From)
+
+		return nil
+	}
+
+	// Removed members can join again with another ID, so after a rotation the host has to let new members in
+	if !c.keyring.Admitted(msg.From) {
+		c.onAdmissionRequest(msg.From, join.Name)
+
+		return nil
+	}
+
+	return c.handOver(msg.From)
+}
+
+// handOver wraps the current key for a member that only knows the key derived from the invite
+func (c *Client) handOver(member string) error {
+	c.publicKeysLock.Lock()
+	publicKey, ok := c.publicKeys[member]
+	c.publicKeysLock.Unlock()
+
+	if !ok {
+		return ErrUnknownMember
+	}
+
+	rekey, err := c.keyring.Wrap(c.keyring.Epoch(), map[string][]byte{
+		member: publicKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.send(v1.TypeRekey, rekey, 0)
+}
+
+// Admit lets a member that joined after the party key has been rotated in and hands the key to it
+func (c *Client) Admit(member string) error {
+	if c.id != c.reference {
+		return ErrNotReference
+	}
+
+	c.keyring.Admit(member)
+
+	if c.keyring.Epoch() == 0 {
+		return nil
+	}
+
+	return c.handOver(member)
+}
+
+func (c *Client) handleRekey(msg v1.Message) error {
+	if msg.From != c.reference {
+		return nil
+	}
+
+	var rekey v1.Rekey
+	if err := json.Unmarshal(msg.Payload, &rekey); err != nil {
+		return err
+	}
+
+	if _, ok := rekey.Keys[c.id]; !ok {
+		return nil
+	}
+
+	c.publicKeysLock.Lock()
+	publicKey, ok := c.publicKeys[c.reference]
+	c.publicKeysLock.Unlock()
+
+	if !ok {
+		return ErrMissingWrapKey
+	}
+
+	if err := c.keyring.Unwrap(rekey, c.id, publicKey); err != nil {
+		return err
+	}
+
+	log.Debug().
+		Int("epoch", rekey.Epoch).
+		Msg("Rotated party key")
+
+	return nil
+}
+
+// Rekey rotates the party key and hands it to every member except the excluded ones
+func (c *Client) Rekey(excluded ...string) error {
+	if c.id != c.reference {
+		return ErrNotReference
+	}
+
+	c.publicKeysLock.Lock()
+	for _, member := range excluded {
+		c.keyring.Exclude(member)
+		delete(c.publicKeys, member)
+	}
+
+	members := map[string][]byte{}
+	for member, publicKey := range c.publicKeys {
+		members[member] = publicKey
+	}
+	c.publicKeysLock.Unlock()
+
+	rekey, err := c.keyring.Rotate(members)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().
+		Int("epoch", rekey.Epoch).
+		Int("members", len(members)).
+		Msg("Rotated party key")
+
+	return c.send(v1.TypeRekey, rekey, 0)
+}
+
+func (c *Client) Send(messageType string, payload interface{}) error {
+	// Joins are sealed with the key derived from the invite so that members who joined later can read them
+	epoch := 0
+	if messageType != v1.TypeJoin {
+		epoch = c.keyring.Epoch()
+	}
+
+	return c.send(messageType, payload, epoch)
+}
+
+func (c *Client) send(messageType string, payload interface{}, epoch int) error {
+	c.transportLock.Lock()
+	transport, opened := c.transport, c.opened
+	c.transportLock.Unlock()
+
+	if !opened {
+		return ErrPartyNotOpen
+	}
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	msg := v1.Message{
+		Version:   v1.Version,
+		Type:      messageType,
+		Room:      c.room,
+		From:      c.id,
+		Timestamp: c.clock.Now().UnixNano(),
+	}
+
+	// The relay only lets a new connection take over our ID if it presents the same token as the old one
+	if messageType == v1.TypeJoin {
+		msg.Token = c.resumeToken
+	}
+
+	sealed, err := c.keyring.Seal(epoch, rawPayload, getAdditionalData(msg))
+	if err != nil {
+		return err
+	}
+
+	msg.Payload, err = json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+
+	return transport.Send(frame)
+}
+
+// open replaces the sealed payload of the message with its plaintext
+func (c *Client) open(msg *v1.Message) error {
+	var sealed v1.Sealed
+	if err := json.Unmarshal(msg.Payload, &sealed); err != nil {
+		return err
+	}
+
+	// The relay can't seal the leave messages it sends for members that dropped
+	if msg.Type == v1.TypeLeave && len(sealed.Ciphertext) == 0 {
+		return nil
+	}
+
+	// Wrapped keys are authenticated by themselves, and joins are always sealed with the invite's key.
+	// The chat history that the relay replays is only readable if it was sealed with the current key, so members
+	// that join or reconnect after a rotation miss the older messages; accepting older keys would let removed members keep chatting.
+	anyEpoch := msg.Type == v1.TypeJoin || msg.Type == v1.TypeRekey
+
+	plaintext, err := c.keyring.Open(sealed, getAdditionalData(*msg), anyEpoch)
+	if err != nil {
+		return err
+	}
+
+	// The relay replays joins and chat messages on purpose; joins are idempotent and chat messages are deduplicated by their timestamp
+	if msg.Type != v1.TypeJoin && msg.Type != v1.TypeChat {
+		if err := c.replays.Check(msg.From, msg.Timestamp, sealed.Nonce); err != nil {
+			return err
+		}
+	}
+
+	msg.Payload = plaintext
+
+	return nil
+}
+
+func getAdditionalData(msg v1.Message) []byte {
+	return []byte(fmt.Sprintf("%v\x00%v\x00%v\x00%v\x00%v", msg.Version, msg.Type, msg.Room, msg.From, msg.Timestamp))
+}
+
+func (c *Client) Wait() error {
+	return <-c.errs
+}
+
+func (c *Client) Close() error {
+	log.Trace().Msg("Closing party client")
+
+	c.transportLock.Lock()
+	transport, opened := c.transport, c.opened
+	c.transportLock.Unlock()
+
+	if !opened {
+		return nil
+	}
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	if err := c.Send(v1.TypeLeave, v1.Leave{}); err != nil {
+		log.Debug().
+			Err(err).
+			Msg("Could not send leave message, closing anyways")
+	}
+
+	return transport.Close()
+}
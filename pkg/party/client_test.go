@@ -0,0 +1,324 @@
+package party
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+const (
+	testRoom      = "room"
+	testSecret    = "secret"
+	testReference = "host"
+
+	testQuietPeriod = time.Millisecond * 200
+)
+
+type testClient struct {
+	*Client
+
+	keyring    *Keyring
+	messages   chan v1.Message
+	admissions chan string
+}
+
+func openTestClient(t *testing.T, hub *MemoryHub, id string) *testClient {
+	t.Helper()
+
+	keyring, err := NewKeyring(testSecret, testRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClient{
+		keyring:    keyring,
+		messages:   make(chan v1.Message, 1024),
+		admissions: make(chan string, 16),
+	}
+
+	c.Client = NewClient(
+		func() Transport {
+			return NewMemoryTransport(hub)
+		},
+		testRoom,
+		keyring,
+		id,
+		id,
+		testReference,
+		time.Millisecond*100,
+		testTimeout,
+		time.Second,
+		8,
+		func(msg v1.Message) {
+			c.messages <- msg
+		},
+		func(id, name string) {
+			c.admissions <- id
+		},
+		func(connected bool) {},
+		context.Background(),
+	)
+
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+func (c *testClient) send(t *testing.T, messageType string, payload interface{}) {
+	t.Helper()
+
+	if err := c.Send(messageType, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect skips messages of other types until one of the given type arrives
+func (c *testClient) expect(t *testing.T, messageType, from string) v1.Message {
+	t.Helper()
+
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-c.messages:
+			if msg.Type == messageType && msg.From == from {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v message from %v", messageType, from)
+		}
+	}
+}
+
+func (c *testClient) expectNone(t *testing.T, messageType string) {
+	t.Helper()
+
+	timeout := time.After(testQuietPeriod)
+	for {
+		select {
+		case msg := <-c.messages:
+			if msg.Type == messageType {
+				t.Fatalf("received unexpected %v message from %v", messageType, msg.From)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func expectChat(t *testing.T, msg v1.Message, text string) {
+	t.Helper()
+
+	var chat v1.Chat
+	if err := json.Unmarshal(msg.Payload, &chat); err != nil {
+		t.Fatal(err)
+	}
+
+	if chat.Text != text {
+		t.Fatalf("expected chat message %q, got %q", text, chat.Text)
+	}
+}
+
+func TestClientMessages(t *testing.T) {
+	hub := NewMemoryHub()
+
+	host := openTestClient(t, hub, testReference)
+	guest := openTestClient(t, hub, "guest")
+
+	host.expect(t, v1.TypeJoin, "guest")
+
+	guest.send(t, v1.TypeChat, v1.Chat{Name: "guest", Text: "hello"})
+	expectChat(t, host.expect(t, v1.TypeChat, "guest"), "hello")
+
+	host.send(t, v1.TypeChat, v1.Chat{Name: "host", Text: "hi"})
+	expectChat(t, guest.expect(t, v1.TypeChat, testReference), "hi")
+}
+
+func TestClientRekeyExcludesMember(t *testing.T) {
+	hub := NewMemoryHub()
+
+	host := openTestClient(t, hub, testReference)
+	guest := openTestClient(t, hub, "guest")
+	kicked := openTestClient(t, hub, "kicked")
+
+	host.expect(t, v1.TypeJoin, "guest")
+	host.expect(t, v1.TypeJoin, "kicked")
+
+	if err := host.Rekey("kicked"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return guest.keyring.Epoch() == 1
+	})
+
+	host.send(t, v1.TypeChat, v1.Chat{Name: "host", Text: "hello"})
+	expectChat(t, guest.expect(t, v1.TypeChat, testReference), "hello")
+	kicked.expectNone(t, v1.TypeChat)
+
+	// Messages sealed with the rotated key are dropped
+	kicked.send(t, v1.TypeChat, v1.Chat{Name: "kicked", Text: "hello"})
+	host.expectNone(t, v1.TypeChat)
+	guest.expectNone(t, v1.TypeChat)
+
+	// Joining again doesn't hand the key to removed members
+	if err := kicked.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rejoined := openTestClient(t, hub, "kicked")
+	renamed := openTestClient(t, hub, "renamed")
+
+	select {
+	case id := <-host.admissions:
+		if id != "renamed" {
+			t.Fatalf("expected admission request from renamed, got %v", id)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for admission request")
+	}
+
+	host.expectNone(t, v1.TypeRekey)
+	if rejoined.keyring.Epoch() != 0 || renamed.keyring.Epoch() != 0 {
+		t.Fatal("removed member received the rotated key")
+	}
+}
+
+func TestClientAdmission(t *testing.T) {
+	hub := NewMemoryHub()
+
+	host := openTestClient(t, hub, testReference)
+	guest := openTestClient(t, hub, "guest")
+
+	host.expect(t, v1.TypeJoin, "guest")
+
+	if err := host.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return guest.keyring.Epoch() == 1
+	})
+
+	// Members that join after the rotation have to be admitted by the host
+	late := openTestClient(t, hub, "late")
+
+	select {
+	case id := <-host.admissions:
+		if id != "late" {
+			t.Fatalf("expected admission request from late, got %v", id)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for admission request")
+	}
+
+	host.send(t, v1.TypeChat, v1.Chat{Name: "host", Text: "hello"})
+	late.expectNone(t, v1.TypeChat)
+
+	if err := host.Admit("late"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		return late.keyring.Epoch() == 1
+	})
+
+	host.send(t, v1.TypeChat, v1.Chat{Name: "host", Text: "welcome"})
+	expectChat(t, late.expect(t, v1.TypeChat, testReference), "welcome")
+
+	// Members that have been admitted get the key again when they join again
+	if err := late.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	returned := openTestClient(t, hub, "late")
+	waitFor(t, func() bool {
+		return returned.keyring.Epoch() == 1
+	})
+
+	select {
+	case id := <-host.admissions:
+		t.Fatalf("unexpected admission request from %v", id)
+	default:
+	}
+}
+
+func TestClientDropsReplayedMessages(t *testing.T) {
+	hub := NewMemoryHub()
+
+	host := openTestClient(t, hub, testReference)
+	guest := openTestClient(t, hub, "guest")
+
+	host.expect(t, v1.TypeJoin, "guest")
+
+	tap := NewMemoryTransport(hub)
+	if err := tap.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer tap.Close()
+
+	guest.send(t, v1.TypePause, v1.Pause{Position: 10})
+	host.expect(t, v1.TypePause, "guest")
+
+	var frame []byte
+	for {
+		candidate, err := tap.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg v1.Message
+		if err := json.Unmarshal(candidate, &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Type == v1.TypePause {
+			frame = candidate
+
+			break
+		}
+	}
+
+	if err := tap.Send(frame); err != nil {
+		t.Fatal(err)
+	}
+	host.expectNone(t, v1.TypePause)
+
+	// Malformed nonces are rejected instead of crashing the client
+	var msg v1.Message
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed v1.Sealed
+	if err := json.Unmarshal(msg.Payload, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	sealed.Nonce = sealed.Nonce[:4]
+
+	rawSealed, err := json.Marshal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Payload = rawSealed
+
+	tampered, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tap.Send(tampered); err != nil {
+		t.Fatal(err)
+	}
+	host.expectNone(t, v1.TypePause)
+
+	guest.send(t, v1.TypePause, v1.Pause{Position: 20})
+	host.expect(t, v1.TypePause, "guest")
+}
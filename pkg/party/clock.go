@@ -0,0 +1,134 @@
+package party
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type ClockSample struct {
+	Offset time.Duration // How far the party clock is ahead of the local clock
+	RTT    time.Duration
+}
+
+// Clock is a monotonic clock which is kept in sync with the party's reference member using NTP-style round trips
+type Clock struct {
+	maxSamples int
+	now        func() time.Time
+
+	base time.Time
+
+	samples     []ClockSample
+	samplesLock sync.Mutex
+}
+
+func NewClock(
+	maxSamples int,
+	now func() time.Time,
+) *Clock {
+	return &Clock{
+		maxSamples: maxSamples,
+		now:        now,
+
+		base: now(),
+
+		samples: []ClockSample{},
+	}
+}
+
+// Local returns the local time, advancing monotonically even if the wall clock is changed
+func (c *Clock) Local() time.Time {
+	return c.base.Add(c.now().Sub(c.base))
+}
+
+// Now returns the party time
+func (c *Clock) Now() time.Time {
+	return c.Local().Add(c.Offset())
+}
+
+// AfterFunc calls f once the party clock reaches `at`
+func (c *Clock) AfterFunc(at time.Time, f func()) *time.Timer {
+	return time.AfterFunc(at.Sub(c.Now()), f)
+}
+
+// AddSample records a round trip; origin and destination are local times, receive and transmit are party times
+func (c *Clock) AddSample(origin, receive, transmit, destination time.Time) (ClockSample, bool) {
+	sample := ClockSample{
+		Offset: (receive.Sub(origin) + transmit.Sub(destination)) / 2,
+		RTT:    destination.Sub(origin) - transmit.Sub(receive),
+	}
+
+	if sample.RTT < 0 {
+		return sample, false
+	}
+
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > c.maxSamples {
+		c.samples = c.samples[len(c.samples)-c.maxSamples:]
+	}
+
+	return sample, true
+}
+
+// Offset returns the median offset of the samples with the lowest round trip times, which are the least affected by queuing delays
+func (c *Clock) Offset() time.Duration {
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	if len(c.samples) == 0 {
+		return 0
+	}
+
+	samples := append([]ClockSample{}, c.samples...)
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].RTT < samples[j].RTT
+	})
+
+	fastest := samples[:(len(samples)+1)/2]
+
+	offsets := []time.Duration{}
+	for _, sample := range fastest {
+		offsets = append(offsets, sample.Offset)
+	}
+
+	return median(offsets)
+}
+
+func (c *Clock) RTT() time.Duration {
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	rtts := []time.Duration{}
+	for _, sample := range c.samples {
+		rtts = append(rtts, sample.RTT)
+	}
+
+	return median(rtts)
+}
+
+func (c *Clock) Synced() bool {
+	c.samplesLock.Lock()
+	defer c.samplesLock.Unlock()
+
+	return len(c.samples) > 0
+}
+
+func median(values []time.Duration) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	if len(sorted)%2 == 0 {
+		return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,129 @@
+package party
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const partyClockSamplesForTest = 16
+
+func TestClockOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		offset     time.Duration // How far the reference clock is ahead of ours
+		forward    time.Duration // Latency from us to the reference member
+		backward   time.Duration // Latency from the reference member to us
+		jitter     time.Duration // Random queuing delay added to both directions
+		spikes     float64       // Share of round trips that are delayed by a queuing spike
+		spike      time.Duration
+		processing time.Duration
+	}{
+		{
+			name:    "in sync",
+			forward: time.Millisecond * 20, backward: time.Millisecond * 20,
+		},
+		{
+			name:    "ahead with symmetric latency",
+			offset:  time.Second * 3,
+			forward: time.Millisecond * 40, backward: time.Millisecond * 40,
+		},
+		{
+			name:    "behind with symmetric latency",
+			offset:  -time.Minute,
+			forward: time.Millisecond * 15, backward: time.Millisecond * 15,
+		},
+		{
+			name:    "ahead with asymmetric latency",
+			offset:  time.Millisecond * 750,
+			forward: time.Millisecond * 60, backward: time.Millisecond * 20,
+		},
+		{
+			name:    "behind with asymmetric latency and jitter",
+			offset:  -time.Second * 2,
+			forward: time.Millisecond * 10, backward: time.Millisecond * 35,
+			jitter:     time.Millisecond * 5,
+			processing: time.Millisecond * 2,
+		},
+		{
+			name:    "queuing spikes",
+			offset:  time.Millisecond * 400,
+			forward: time.Millisecond * 25, backward: time.Millisecond * 25,
+			jitter: time.Millisecond * 2,
+			spikes: 0.3, spike: time.Millisecond * 800,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			delay := func(base time.Duration) time.Duration {
+				d := base
+				if tt.jitter > 0 {
+					d += time.Duration(r.Int63n(int64(tt.jitter)))
+				}
+
+				if r.Float64() < tt.spikes {
+					d += tt.spike
+				}
+
+				return d
+			}
+
+			local := time.Unix(1000, 0)
+			clock := NewClock(partyClockSamplesForTest, func() time.Time {
+				return local
+			})
+
+			for i := 0; i < partyClockSamplesForTest*2; i++ {
+				origin := clock.Local()
+
+				local = local.Add(delay(tt.forward))
+				receive := local.Add(tt.offset)
+
+				local = local.Add(tt.processing)
+				transmit := local.Add(tt.offset)
+
+				local = local.Add(delay(tt.backward))
+
+				clock.AddSample(origin, receive, transmit, clock.Local())
+
+				local = local.Add(time.Second)
+			}
+
+			// NTP-style synchronization can't tell asymmetric latency apart from an offset, so it is off by half of the asymmetry at most
+			asymmetry := (tt.forward - tt.backward) / 2
+			if asymmetry < 0 {
+				asymmetry = -asymmetry
+			}
+			tolerance := asymmetry + tt.jitter + time.Millisecond
+
+			if drift := clock.Offset() - tt.offset; drift > tolerance || drift < -tolerance {
+				t.Fatalf("offset %v is %v away from %v, expected at most %v", clock.Offset(), drift, tt.offset, tolerance)
+			}
+
+			if drift := clock.Now().Sub(local.Add(tt.offset)); drift > tolerance || drift < -tolerance {
+				t.Fatalf("party time is %v away from the reference clock, expected at most %v", drift, tolerance)
+			}
+
+			if !clock.Synced() {
+				t.Fatal("clock is not synced after adding samples")
+			}
+		})
+	}
+}
+
+func TestClockRejectsImpossibleSamples(t *testing.T) {
+	clock := NewClock(partyClockSamplesForTest, time.Now)
+
+	origin := time.Now()
+
+	// The reference member can't have spent more time on the request than the round trip took
+	if _, ok := clock.AddSample(origin, origin.Add(time.Second), origin.Add(time.Second*3), origin.Add(time.Second)); ok {
+		t.Fatal("accepted sample with negative round trip time")
+	}
+
+	if clock.Synced() {
+		t.Fatal("clock is synced without valid samples")
+	}
+}
@@ -0,0 +1,83 @@
+package party
+
+import (
+	"math"
+	"time"
+)
+
+type Correction struct {
+	Speed    float64       // Playback speed to nudge the local player to; 1 if no nudge is required
+	Seek     bool          // Whether the drift is too large to be nudged away and requires an absolute seek
+	Position time.Duration // Position to seek to if Seek is set
+	Drift    time.Duration // Positive if the local player is behind the remote one
+}
+
+type DriftCorrector struct {
+	speedThreshold time.Duration
+	seekThreshold  time.Duration
+	maxSpeedOffset float64
+	catchUpTime    time.Duration
+}
+
+func NewDriftCorrector(
+	speedThreshold time.Duration,
+	seekThreshold time.Duration,
+	maxSpeedOffset float64,
+	catchUpTime time.Duration,
+) *DriftCorrector {
+	return &DriftCorrector{
+		speedThreshold: speedThreshold,
+		seekThreshold:  seekThreshold,
+		maxSpeedOffset: maxSpeedOffset,
+		catchUpTime:    catchUpTime,
+	}
+}
+
+// Expected returns where a remote player that was at `position` at `at` (party clock) is now
+func (d *DriftCorrector) Expected(position time.Duration, paused bool, at, now time.Time) time.Duration {
+	if paused {
+		return position
+	}
+
+	return position + now.Sub(at)
+}
+
+// Correct returns how to bring the local player to the remote position; `seekPrecision` is the granularity in which the local player can seek
+func (d *DriftCorrector) Correct(local, remote, seekPrecision time.Duration) Correction {
+	drift := remote - local
+
+	absDrift := drift
+	if absDrift < 0 {
+		absDrift = -absDrift
+	}
+
+	// Players that can't seek precisely would miss the position and seek back and forth, so smaller drifts are nudged away instead
+	seekThreshold := d.seekThreshold
+	if minSeekThreshold := seekPrecision * 2; seekThreshold < minSeekThreshold {
+		seekThreshold = minSeekThreshold
+	}
+
+	if absDrift >= seekThreshold {
+		return Correction{
+			Speed:    1,
+			Seek:     true,
+			Position: remote,
+			Drift:    drift,
+		}
+	}
+
+	if absDrift < d.speedThreshold {
+		return Correction{
+			Speed: 1,
+			Drift: drift,
+		}
+	}
+
+	// Try to catch up within `catchUpTime`, but never change the speed noticeably
+	offset := math.Max(-d.maxSpeedOffset, math.Min(d.maxSpeedOffset, drift.Seconds()/d.catchUpTime.Seconds()))
+
+	return Correction{
+		Speed: math.Round((1+offset)*100) / 100,
+		Drift: drift,
+	}
+}
@@ -0,0 +1,49 @@
+package party
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDriftCorrector(t *testing.T) {
+	corrector := NewDriftCorrector(time.Millisecond*100, time.Millisecond*500, 0.1, time.Second*10)
+
+	tests := []struct {
+		name          string
+		local         time.Duration
+		remote        time.Duration
+		seekPrecision time.Duration
+		speed         float64
+		seek          bool
+	}{
+		{"in sync", time.Second * 10, time.Second * 10, 0, 1, false},
+		{"slightly behind", time.Second * 10, time.Millisecond * 10300, 0, 1.03, false},
+		{"slightly ahead", time.Millisecond * 10300, time.Second * 10, 0, 0.97, false},
+		{"far behind", time.Second * 10, time.Second * 11, 0, 1, true},
+		{"far ahead with precise seeks", time.Second * 11, time.Second * 10, time.Millisecond, 1, true},
+		{"behind with imprecise seeks", time.Second * 10, time.Millisecond * 11500, time.Second, 1.1, false},
+		{"far behind with imprecise seeks", time.Second * 10, time.Second * 12, time.Second, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			correction := corrector.Correct(tt.local, tt.remote, tt.seekPrecision)
+
+			if correction.Seek != tt.seek {
+				t.Fatalf("expected seek to be %v, got %v", tt.seek, correction.Seek)
+			}
+
+			if correction.Seek && correction.Position != tt.remote {
+				t.Fatalf("expected seek to %v, got %v", tt.remote, correction.Position)
+			}
+
+			if correction.Speed != tt.speed {
+				t.Fatalf("expected speed %v, got %v", tt.speed, correction.Speed)
+			}
+
+			if correction.Drift != tt.remote-tt.local {
+				t.Fatalf("expected drift %v, got %v", tt.remote-tt.local, correction.Drift)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package party
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const (
+	InviteScheme = "vintangle"
+	inviteHost   = "join"
+
+	inviteMagnetKey = "magnet"
+	invitePathKey   = "path"
+	inviteServerKey = "server"
+	inviteRoomKey   = "room"
+	inviteSecretKey = "secret"
+	inviteHostKey   = "host"
+
+	roomLength        = 12
+	secretLength      = 32
+	memberLength      = 20
+	resumeTokenLength = 32
+)
+
+var (
+	ErrInvalidInvite = errors.New("could not parse invalid invite")
+)
+
+type Invite struct {
+	Magnet string
+	Path   string
+	Server string
+	Room   string
+	Secret string
+	Host   string
+}
+
+func NewInvite(magnet, path, server, room string) (Invite, error) {
+	if strings.TrimSpace(room) == "" {
+		r, err := randomString(roomLength)
+		if err != nil {
+			return Invite{}, err
+		}
+
+		room = r
+	}
+
+	secret, err := randomString(secretLength)
+	if err != nil {
+		return Invite{}, err
+	}
+
+	host, err := NewMemberID()
+	if err != nil {
+		return Invite{}, err
+	}
+
+	return Invite{
+		Magnet: magnet,
+		Path:   path,
+		Server: server,
+		Room:   room,
+		Secret: secret,
+		Host:   host,
+	}, nil
+}
+
+func IsInvite(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), InviteScheme+"://")
+}
+
+func ParseInvite(s string) (Invite, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return Invite{}, err
+	}
+
+	if u.Scheme != InviteScheme || u.Host != inviteHost {
+		return Invite{}, ErrInvalidInvite
+	}
+
+	q := u.Query()
+
+	invite := Invite{
+		Magnet: q.Get(inviteMagnetKey),
+		Path:   q.Get(invitePathKey),
+		Server: q.Get(inviteServerKey),
+		Room:   q.Get(inviteRoomKey),
+		Secret: q.Get(inviteSecretKey),
+		Host:   q.Get(inviteHostKey),
+	}
+
+	if invite.Magnet == "" || invite.Path == "" {
+		return Invite{}, ErrInvalidInvite
+	}
+
+	return invite, nil
+}
+
+func (i Invite) String() string {
+	q := url.Values{}
+	q.Set(inviteMagnetKey, i.Magnet)
+	q.Set(invitePathKey, i.Path)
+
+	if i.Server != "" {
+		q.Set(inviteServerKey, i.Server)
+		q.Set(inviteRoomKey, i.Room)
+		q.Set(inviteSecretKey, i.Secret)
+		q.Set(inviteHostKey, i.Host)
+	}
+
+	return (&url.URL{
+		Scheme:   InviteScheme,
+		Host:     inviteHost,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+func NewMemberID() (string, error) {
+	return randomString(memberLength)
+}
+
+// newResumeToken returns a secret that proves to the relay that a new connection belongs to the same member
+func newResumeToken() (string, error) {
+	return randomString(resumeTokenLength)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)[:n], nil
+}
@@ -0,0 +1,299 @@
+package party
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	keyLength = 32
+	nonceSize = 12 // Standard AES-GCM nonce size
+
+	roomKeyInfo = "vintangle party room key"
+	wrapKeyInfo = "vintangle party wrap key"
+)
+
+var (
+	ErrUnknownEpoch   = errors.New("could not find key for epoch")
+	ErrStaleEpoch     = errors.New("message was sealed with a key that has been rotated")
+	ErrMissingWrapKey = errors.New("could not find wrapped key for member")
+	ErrInvalidNonce   = errors.New("invalid nonce size")
+)
+
+// Keyring holds the symmetric keys of a party; epoch 0 is derived from the invite secret, later epochs are distributed by the host to the members it has admitted
+type Keyring struct {
+	keys     map[int][]byte
+	epoch    int
+	admitted map[string]struct{}
+	excluded map[string]struct{}
+	keysLock sync.Mutex
+
+	privateKey []byte
+	publicKey  []byte
+}
+
+func NewKeyring(secret, room string) (*Keyring, error) {
+	roomKey, err := deriveKey([]byte(secret), []byte(room), roomKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keyring{
+		keys: map[int][]byte{
+			0: roomKey,
+		},
+		admitted: map[string]struct{}{},
+		excluded: map[string]struct{}{},
+
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}, nil
+}
+
+func (k *Keyring) PublicKey() []byte {
+	return k.publicKey
+}
+
+func (k *Keyring) Epoch() int {
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	return k.epoch
+}
+
+// Admit allows the members to receive the current and future keys, even if they have been excluded before
+func (k *Keyring) Admit(members ...string) {
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	for _, member := range members {
+		k.admitted[member] = struct{}{}
+		delete(k.excluded, member)
+	}
+}
+
+// Exclude prevents the member from receiving any further keys
+func (k *Keyring) Exclude(member string) {
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	k.excluded[member] = struct{}{}
+	delete(k.admitted, member)
+}
+
+func (k *Keyring) Admitted(member string) bool {
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	_, ok := k.admitted[member]
+
+	return ok
+}
+
+func (k *Keyring) Excluded(member string) bool {
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	_, ok := k.excluded[member]
+
+	return ok
+}
+
+// Seal encrypts and authenticates the plaintext with the key of the given epoch
+func (k *Keyring) Seal(epoch int, plaintext, additionalData []byte) (v1.Sealed, error) {
+	k.keysLock.Lock()
+	key, ok := k.keys[epoch]
+	k.keysLock.Unlock()
+
+	if !ok {
+		return v1.Sealed{}, ErrUnknownEpoch
+	}
+
+	nonce, ciphertext, err := seal(key, plaintext, additionalData)
+	if err != nil {
+		return v1.Sealed{}, err
+	}
+
+	return v1.Sealed{
+		Epoch:      epoch,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts and authenticates the sealed message; unless `anyEpoch` is set, only messages sealed with the current key are accepted
+func (k *Keyring) Open(sealed v1.Sealed, additionalData []byte, anyEpoch bool) ([]byte, error) {
+	k.keysLock.Lock()
+	key, ok := k.keys[sealed.Epoch]
+	epoch := k.epoch
+	k.keysLock.Unlock()
+
+	if !ok {
+		return nil, ErrUnknownEpoch
+	}
+
+	if !anyEpoch && sealed.Epoch != epoch {
+		return nil, ErrStaleEpoch
+	}
+
+	return open(key, sealed.Nonce, sealed.Ciphertext, additionalData)
+}
+
+// Rotate creates a new key, wraps it for the members with the given public keys and admits them
+func (k *Keyring) Rotate(members map[string][]byte) (v1.Rekey, error) {
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return v1.Rekey{}, err
+	}
+
+	k.keysLock.Lock()
+	k.epoch++
+	k.keys[k.epoch] = key
+	for member := range members {
+		k.admitted[member] = struct{}{}
+	}
+	epoch := k.epoch
+	k.keysLock.Unlock()
+
+	return k.Wrap(epoch, members)
+}
+
+// Wrap encrypts the key of the given epoch for each of the members with the given public keys
+func (k *Keyring) Wrap(epoch int, members map[string][]byte) (v1.Rekey, error) {
+	k.keysLock.Lock()
+	key, ok := k.keys[epoch]
+	k.keysLock.Unlock()
+
+	if !ok {
+		return v1.Rekey{}, ErrUnknownEpoch
+	}
+
+	rekey := v1.Rekey{
+		Epoch: epoch,
+		Keys:  map[string][]byte{},
+	}
+
+	for member, publicKey := range members {
+		wrapKey, err := k.wrapKey(publicKey, member)
+		if err != nil {
+			return v1.Rekey{}, err
+		}
+
+		nonce, ciphertext, err := seal(wrapKey, key, []byte(fmt.Sprintf("%v", epoch)))
+		if err != nil {
+			return v1.Rekey{}, err
+		}
+
+		rekey.Keys[member] = append(nonce, ciphertext...)
+	}
+
+	return rekey, nil
+}
+
+// Unwrap decrypts the key that the owner of `fromPublicKey` wrapped for `member` and makes it the current key
+func (k *Keyring) Unwrap(rekey v1.Rekey, member string, fromPublicKey []byte) error {
+	wrapped, ok := rekey.Keys[member]
+	if !ok {
+		return ErrMissingWrapKey
+	}
+
+	wrapKey, err := k.wrapKey(fromPublicKey, member)
+	if err != nil {
+		return err
+	}
+
+	if len(wrapped) < nonceSize {
+		return ErrMissingWrapKey
+	}
+
+	key, err := open(wrapKey, wrapped[:nonceSize], wrapped[nonceSize:], []byte(fmt.Sprintf("%v", rekey.Epoch)))
+	if err != nil {
+		return err
+	}
+
+	k.keysLock.Lock()
+	defer k.keysLock.Unlock()
+
+	k.keys[rekey.Epoch] = key
+	if rekey.Epoch > k.epoch {
+		k.epoch = rekey.Epoch
+	}
+
+	return nil
+}
+
+func (k *Keyring) wrapKey(publicKey []byte, member string) ([]byte, error) {
+	shared, err := curve25519.X25519(k.privateKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveKey(shared, []byte(member), wrapKeyInfo)
+}
+
+func deriveKey(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, keyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func open(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// GCM panics instead of failing if the nonce has the wrong size
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrInvalidNonce
+	}
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
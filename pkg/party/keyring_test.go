@@ -0,0 +1,128 @@
+package party
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+func TestKeyringRejectsInvalidNonce(t *testing.T) {
+	keyring, err := NewKeyring("secret", "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := keyring.Seal(0, []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nonce := range [][]byte{nil, sealed.Nonce[:4], append(sealed.Nonce, 0)} {
+		if _, err := keyring.Open(v1.Sealed{
+			Epoch:      sealed.Epoch,
+			Nonce:      nonce,
+			Ciphertext: sealed.Ciphertext,
+		}, nil, false); !errors.Is(err, ErrInvalidNonce) {
+			t.Fatalf("expected %v for nonce of size %v, got %v", ErrInvalidNonce, len(nonce), err)
+		}
+	}
+
+	if plaintext, err := keyring.Open(sealed, nil, false); err != nil || string(plaintext) != "hello" {
+		t.Fatalf("could not open sealed message: %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	host, err := NewKeyring("secret", "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := NewKeyring("secret", "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rekey, err := host.Rotate(map[string][]byte{
+		"guest": guest.PublicKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !host.Admitted("guest") {
+		t.Fatal("member that received the rotated key isn't admitted")
+	}
+
+	if err := guest.Unwrap(rekey, "guest", host.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := host.Seal(host.Epoch(), []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := guest.Open(sealed, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := guest.Seal(0, []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := host.Open(old, nil, false); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("expected %v for message sealed with rotated key, got %v", ErrStaleEpoch, err)
+	}
+
+	host.Exclude("guest")
+	if host.Admitted("guest") || !host.Excluded("guest") {
+		t.Fatal("excluded member is still admitted")
+	}
+
+	host.Admit("guest")
+	if !host.Admitted("guest") || host.Excluded("guest") {
+		t.Fatal("admitted member is still excluded")
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	guard := NewReplayGuard(time.Minute)
+
+	now := time.Now().UnixNano()
+
+	if err := guard.Check("a", now, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guard.Check("a", now, []byte("1")); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected %v for duplicate nonce, got %v", ErrReplayed, err)
+	}
+
+	// Nonces are tracked per sender
+	if err := guard.Check("b", now, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages can arrive out of order
+	if err := guard.Check("a", now-time.Second.Nanoseconds(), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	later := now + (time.Minute * 2).Nanoseconds()
+	if err := guard.Check("a", later, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guard.Check("a", now, []byte("4")); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected %v for message outside of window, got %v", ErrExpired, err)
+	}
+
+	// Other senders' clocks are independent
+	if err := guard.Check("b", now, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+}
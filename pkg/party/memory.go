@@ -0,0 +1,132 @@
+package party
+
+import (
+	"net"
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+const (
+	memoryTransportBufferSize = 128
+)
+
+// MemoryHub connects memory transports in the same process, i.e. to simulate a party in tests
+type MemoryHub struct {
+	transports     map[*MemoryTransport]struct{}
+	joins          map[*MemoryTransport][]byte
+	transportsLock sync.Mutex
+}
+
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{
+		transports: map[*MemoryTransport]struct{}{},
+		joins:      map[*MemoryTransport][]byte{},
+	}
+}
+
+func (h *MemoryHub) broadcast(from *MemoryTransport, frame []byte) {
+	h.transportsLock.Lock()
+	defer h.transportsLock.Unlock()
+
+	for t := range h.transports {
+		if t == from {
+			continue
+		}
+
+		select {
+		case t.frames <- frame:
+		case <-t.done:
+		}
+	}
+}
+
+// MemoryTransport delivers frames to all other transports of its hub; like the relay, it acknowledges heartbeats and replays the joins of the other members, but it doesn't synthesize leaves
+type MemoryTransport struct {
+	hub *MemoryHub
+
+	frames chan []byte
+	done   chan struct{}
+
+	closeOnce sync.Once
+}
+
+func NewMemoryTransport(hub *MemoryHub) *MemoryTransport {
+	return &MemoryTransport{
+		hub: hub,
+
+		frames: make(chan []byte, memoryTransportBufferSize),
+		done:   make(chan struct{}),
+	}
+}
+
+func (t *MemoryTransport) Open() error {
+	t.hub.transportsLock.Lock()
+	defer t.hub.transportsLock.Unlock()
+
+	t.hub.transports[t] = struct{}{}
+
+	for _, join := range t.hub.joins {
+		select {
+		case t.frames <- join:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (t *MemoryTransport) Send(frame []byte) error {
+	select {
+	case <-t.done:
+		return net.ErrClosed
+	default:
+	}
+
+	var msg v1.Message
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return err
+	}
+
+	if msg.Type == v1.TypeHeartbeat {
+		select {
+		case t.frames <- append([]byte{}, frame...):
+		case <-t.done:
+		}
+
+		return nil
+	}
+
+	if msg.Type == v1.TypeJoin {
+		t.hub.transportsLock.Lock()
+		t.hub.joins[t] = append([]byte{}, frame...)
+		t.hub.transportsLock.Unlock()
+	}
+
+	t.hub.broadcast(t, append([]byte{}, frame...))
+
+	return nil
+}
+
+func (t *MemoryTransport) Receive() ([]byte, error) {
+	select {
+	case frame := <-t.frames:
+		return frame, nil
+	case <-t.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (t *MemoryTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+
+		t.hub.transportsLock.Lock()
+		defer t.hub.transportsLock.Unlock()
+
+		delete(t.hub.transports, t)
+		delete(t.hub.joins, t)
+	})
+
+	return nil
+}
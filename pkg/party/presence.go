@@ -0,0 +1,49 @@
+package party
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+type presence struct {
+	presence v1.Presence
+	at       time.Time
+}
+
+// Presences tracks the last reported playback and download state of party members
+type Presences struct {
+	presences     map[string]presence
+	presencesLock sync.Mutex
+}
+
+func NewPresences() *Presences {
+	return &Presences{
+		presences: map[string]presence{},
+	}
+}
+
+func (p *Presences) Set(member string, memberPresence v1.Presence, at time.Time) {
+	p.presencesLock.Lock()
+	defer p.presencesLock.Unlock()
+
+	p.presences[member] = presence{memberPresence, at}
+}
+
+// Get returns the member's presence and the party time at which it was reported
+func (p *Presences) Get(member string) (v1.Presence, time.Time, bool) {
+	p.presencesLock.Lock()
+	defer p.presencesLock.Unlock()
+
+	memberPresence, ok := p.presences[member]
+
+	return memberPresence.presence, memberPresence.at, ok
+}
+
+func (p *Presences) Remove(member string) {
+	p.presencesLock.Lock()
+	defer p.presencesLock.Unlock()
+
+	delete(p.presences, member)
+}
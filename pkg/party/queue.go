@@ -0,0 +1,100 @@
+package party
+
+import (
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+// Queue holds the items the party will play next; the host edits it and everyone else mirrors it
+type Queue struct {
+	items     []v1.QueueItem
+	itemsLock sync.Mutex
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		items: []v1.QueueItem{},
+	}
+}
+
+func (q *Queue) Get() v1.Queue {
+	q.itemsLock.Lock()
+	defer q.itemsLock.Unlock()
+
+	return v1.Queue{
+		Items: append([]v1.QueueItem{}, q.items...),
+	}
+}
+
+func (q *Queue) Set(queue v1.Queue) {
+	q.itemsLock.Lock()
+	defer q.itemsLock.Unlock()
+
+	q.items = append([]v1.QueueItem{}, queue.Items...)
+}
+
+func (q *Queue) Add(item v1.QueueItem) v1.Queue {
+	q.itemsLock.Lock()
+	q.items = append(q.items, item)
+	q.itemsLock.Unlock()
+
+	return q.Get()
+}
+
+func (q *Queue) Remove(id string) v1.Queue {
+	q.itemsLock.Lock()
+	if i := q.index(id); i >= 0 {
+		q.items = append(q.items[:i], q.items[i+1:]...)
+	}
+	q.itemsLock.Unlock()
+
+	return q.Get()
+}
+
+// Move shifts the item by the given offset, i.e. -1 to play it earlier; it stops at the start and end of the queue
+func (q *Queue) Move(id string, offset int) v1.Queue {
+	q.itemsLock.Lock()
+	if i := q.index(id); i >= 0 {
+		j := i + offset
+		if j < 0 {
+			j = 0
+		}
+
+		if j > len(q.items)-1 {
+			j = len(q.items) - 1
+		}
+
+		item := q.items[i]
+		q.items = append(q.items[:i], q.items[i+1:]...)
+		q.items = append(q.items[:j], append([]v1.QueueItem{item}, q.items[j:]...)...)
+	}
+	q.itemsLock.Unlock()
+
+	return q.Get()
+}
+
+// Next removes the first item from the queue and returns it
+func (q *Queue) Next() (v1.QueueItem, bool) {
+	q.itemsLock.Lock()
+	defer q.itemsLock.Unlock()
+
+	if len(q.items) == 0 {
+		return v1.QueueItem{}, false
+	}
+
+	item := q.items[0]
+	q.items = q.items[1:]
+
+	return item, true
+}
+
+func (q *Queue) index(id string) int {
+	for i, item := range q.items {
+		if item.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
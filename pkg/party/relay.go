@@ -0,0 +1,492 @@
+package party
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	memberSendBufferSize = 128
+	chatHistorySize      = 50
+)
+
+var (
+	ErrJoinExpected    = errors.New("expected join message as first message")
+	ErrMemberMismatch  = errors.New("message does not match the member's room or ID")
+	ErrMemberTooSlow   = errors.New("member could not keep up with messages")
+	ErrMemberExists    = errors.New("member is already connected and could not prove that it is resuming")
+	ErrRelayNotOpen    = errors.New("relay is not open")
+	errMemberLeftEarly = errors.New("member left")
+)
+
+type member struct {
+	id          string
+	resumeToken string
+	join        []byte
+	conn        net.Conn
+	send        chan []byte
+}
+
+type room struct {
+	members    map[string]*member
+	chat       [][]byte
+	lastActive time.Time
+}
+
+// Relay forwards frames between the members of each room; since it can't read the sealed payloads, it can't tell who the host is and doesn't enforce kicks. Clients ignore messages from kicked members instead, and the host rotates the party key so that they can't read or forge any further ones.
+type Relay struct {
+	laddr         string
+	memberTimeout time.Duration
+	roomTTL       time.Duration
+
+	listener net.Listener
+
+	rooms     map[string]*room
+	roomsLock sync.Mutex
+
+	errs chan error
+
+	ctx context.Context
+}
+
+func NewRelay(
+	laddr string,
+	memberTimeout time.Duration,
+	roomTTL time.Duration,
+
+	ctx context.Context,
+) *Relay {
+	return &Relay{
+		laddr:         laddr,
+		memberTimeout: memberTimeout,
+		roomTTL:       roomTTL,
+
+		rooms: map[string]*room{},
+
+		errs: make(chan error, 1),
+
+		ctx: ctx,
+	}
+}
+
+func (r *Relay) Open() error {
+	log.Trace().Msg("Opening relay")
+
+	listener, err := net.Listen("tcp", r.laddr)
+	if err != nil {
+		return err
+	}
+	r.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					r.errs <- nil
+
+					return
+				}
+
+				r.errs <- err
+
+				return
+			}
+
+			go func() {
+				if err := r.handleConn(conn); err != nil && !errors.Is(err, errMemberLeftEarly) {
+					log.Debug().
+						Str("address", conn.RemoteAddr().String()).
+						Err(err).
+						Msg("Closed connection to member")
+				}
+			}()
+		}
+	}()
+
+	// A TTL of zero keeps empty rooms around forever
+	if r.roomTTL <= 0 {
+		return nil
+	}
+
+	interval := r.roomTTL / 2
+	if interval <= 0 {
+		interval = r.roomTTL
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				r.expireRooms()
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *Relay) Addr() net.Addr {
+	if r.listener == nil {
+		return nil
+	}
+
+	return r.listener.Addr()
+}
+
+func (r *Relay) Rooms() map[string][]string {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	rooms := map[string][]string{}
+	for roomID, rm := range r.rooms {
+		members := []string{}
+		for memberID := range rm.members {
+			members = append(members, memberID)
+		}
+
+		rooms[roomID] = members
+	}
+
+	return rooms
+}
+
+func (r *Relay) Wait() error {
+	return <-r.errs
+}
+
+func (r *Relay) Close() error {
+	log.Trace().Msg("Closing relay")
+
+	if r.listener == nil {
+		return ErrRelayNotOpen
+	}
+
+	if err := r.listener.Close(); err != nil {
+		return err
+	}
+
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	for _, rm := range r.rooms {
+		for _, m := range rm.members {
+			_ = m.conn.Close()
+		}
+	}
+
+	return nil
+}
+
+func (r *Relay) expireRooms() {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	for roomID, rm := range r.rooms {
+		if len(rm.members) == 0 && time.Since(rm.lastActive) > r.roomTTL {
+			log.Debug().
+				Str("room", roomID).
+				Msg("Expiring room")
+
+			delete(r.rooms, roomID)
+		}
+	}
+}
+
+// join adds the member to the room and returns the frames it needs to catch up on
+func (r *Relay) join(roomID string, m *member) ([][]byte, error) {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	rm, ok := r.rooms[roomID]
+	if !ok {
+		log.Debug().
+			Str("room", roomID).
+			Msg("Creating room")
+
+		rm = &room{
+			members: map[string]*member{},
+		}
+		r.rooms[roomID] = rm
+	}
+
+	// A member that reconnects takes over from its old connection, which we might not have noticed to be gone yet; anyone else has to wait for that connection to time out
+	if stale, ok := rm.members[m.id]; ok {
+		if m.resumeToken == "" || subtle.ConstantTimeCompare([]byte(stale.resumeToken), []byte(m.resumeToken)) != 1 {
+			log.Warn().
+				Str("room", roomID).
+				Str("member", m.id).
+				Err(ErrMemberExists).
+				Msg("Rejecting connection")
+
+			return nil, ErrMemberExists
+		}
+
+		log.Debug().
+			Str("room", roomID).
+			Str("member", m.id).
+			Msg("Member reconnected, closing old connection")
+
+		_ = stale.conn.Close()
+	}
+
+	history := [][]byte{}
+	for _, existing := range rm.members {
+		if existing.id == m.id {
+			continue
+		}
+
+		history = append(history, existing.join)
+	}
+	history = append(history, rm.chat...)
+
+	rm.members[m.id] = m
+	rm.lastActive = time.Now()
+
+	return history, nil
+}
+
+// leave removes the member from the room; it returns false if the member has already been replaced by a new connection
+func (r *Relay) leave(roomID string, m *member) bool {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	rm, ok := r.rooms[roomID]
+	if !ok {
+		return false
+	}
+	rm.lastActive = time.Now()
+
+	if rm.members[m.id] != m {
+		return false
+	}
+	delete(rm.members, m.id)
+
+	return true
+}
+
+func (r *Relay) recordChat(roomID string, raw []byte) {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	rm, ok := r.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	rm.chat = append(rm.chat, raw)
+	if len(rm.chat) > chatHistorySize {
+		rm.chat = rm.chat[len(rm.chat)-chatHistorySize:]
+	}
+}
+
+func (r *Relay) broadcast(roomID string, from *member, raw []byte) {
+	r.roomsLock.Lock()
+	defer r.roomsLock.Unlock()
+
+	rm, ok := r.rooms[roomID]
+	if !ok {
+		return
+	}
+	rm.lastActive = time.Now()
+
+	for _, m := range rm.members {
+		if m == from {
+			continue
+		}
+
+		select {
+		case m.send <- raw:
+		default:
+			log.Warn().
+				Str("room", roomID).
+				Str("member", m.id).
+				Err(ErrMemberTooSlow).
+				Msg("Disconnecting member")
+
+			_ = m.conn.Close()
+		}
+	}
+}
+
+func (r *Relay) handleConn(conn net.Conn) error {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if err := conn.SetReadDeadline(time.Now().Add(r.memberTimeout)); err != nil {
+		return err
+	}
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		return errMemberLeftEarly
+	}
+
+	var join v1.Message
+	if err := json.Unmarshal(scanner.Bytes(), &join); err != nil {
+		return err
+	}
+
+	if join.Version != v1.Version {
+		return ErrUnsupportedVersion
+	}
+
+	if join.Type != v1.TypeJoin || join.Room == "" || join.From == "" {
+		return ErrJoinExpected
+	}
+
+	// The others must never see the resume token, or they could take over the member's ID
+	resumeToken := join.Token
+	join.Token = ""
+
+	rawJoin, err := json.Marshal(join)
+	if err != nil {
+		return err
+	}
+
+	m := &member{
+		id:          join.From,
+		resumeToken: resumeToken,
+		join:        append(rawJoin, '\n'),
+		conn:        conn,
+		send:        make(chan []byte, memberSendBufferSize),
+	}
+
+	history, err := r.join(join.Room, m)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("room", join.Room).
+		Str("member", m.id).
+		Msg("Member joined")
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case raw := <-m.send:
+				if _, err := conn.Write(raw); err != nil {
+					_ = conn.Close()
+
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Let the new member know who is already in the room and what they have been talking about
+	for _, raw := range history {
+		select {
+		case m.send <- raw:
+		default:
+			return ErrMemberTooSlow
+		}
+	}
+
+	left := false
+	defer func() {
+		if !r.leave(join.Room, m) {
+			log.Info().
+				Str("room", join.Room).
+				Str("member", m.id).
+				Msg("Member resumed on new connection")
+
+			return
+		}
+
+		if !left {
+			// Let the others know that the member is gone even if it could not say goodbye itself
+			leave, err := json.Marshal(v1.Message{
+				Version:   v1.Version,
+				Type:      v1.TypeLeave,
+				Room:      join.Room,
+				From:      m.id,
+				Timestamp: time.Now().UnixNano(),
+				Payload:   []byte("{}"),
+			})
+			if err == nil {
+				r.broadcast(join.Room, m, append(leave, '\n'))
+			}
+		}
+
+		log.Info().
+			Str("room", join.Room).
+			Str("member", m.id).
+			Msg("Member left")
+	}()
+
+	r.broadcast(join.Room, m, m.join)
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(r.memberTimeout)); err != nil {
+			return err
+		}
+
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		var msg v1.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+
+		if msg.Version != v1.Version {
+			return ErrUnsupportedVersion
+		}
+
+		if msg.Room != join.Room || msg.From != m.id {
+			return ErrMemberMismatch
+		}
+
+		// Acknowledge heartbeats so that the member can tell whether its connection is still alive
+		if msg.Type == v1.TypeHeartbeat {
+			select {
+			case m.send <- append(append([]byte{}, scanner.Bytes()...), '\n'):
+			default:
+				return ErrMemberTooSlow
+			}
+
+			continue
+		}
+
+		if msg.Type == v1.TypeLeave {
+			left = true
+		}
+
+		raw := append(append([]byte{}, scanner.Bytes()...), '\n')
+		if msg.Type == v1.TypeChat {
+			r.recordChat(join.Room, raw)
+		}
+
+		r.broadcast(join.Room, m, raw)
+
+		if left {
+			return nil
+		}
+	}
+}
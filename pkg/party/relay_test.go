@@ -0,0 +1,324 @@
+package party
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+const testTimeout = time.Second * 5
+
+type testMember struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func openTestRelay(t *testing.T, roomTTL time.Duration) *Relay {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	relay := NewRelay("127.0.0.1:0", testTimeout, roomTTL, ctx)
+	if err := relay.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = relay.Close()
+	})
+
+	return relay
+}
+
+func joinTestRelay(t *testing.T, relay *Relay, room, id string) *testMember {
+	t.Helper()
+
+	return resumeTestRelay(t, relay, room, id, "")
+}
+
+func resumeTestRelay(t *testing.T, relay *Relay, room, id, resumeToken string) *testMember {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", relay.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	m := &testMember{
+		conn:    conn,
+		scanner: bufio.NewScanner(conn),
+	}
+	m.write(t, v1.Message{
+		Version:   v1.Version,
+		Type:      v1.TypeJoin,
+		Room:      room,
+		From:      id,
+		Timestamp: time.Now().UnixNano(),
+		Token:     resumeToken,
+	})
+
+	return m
+}
+
+func (m *testMember) send(t *testing.T, room, from, messageType string) {
+	t.Helper()
+
+	m.write(t, v1.Message{
+		Version:   v1.Version,
+		Type:      messageType,
+		Room:      room,
+		From:      from,
+		Timestamp: time.Now().UnixNano(),
+	})
+}
+
+func (m *testMember) write(t *testing.T, msg v1.Message) {
+	t.Helper()
+
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.conn.Write(append(frame, '\n')); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *testMember) receive(t *testing.T) v1.Message {
+	t.Helper()
+
+	if err := m.conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.scanner.Scan() {
+		t.Fatalf("could not receive frame: %v", m.scanner.Err())
+	}
+
+	var msg v1.Message
+	if err := json.Unmarshal(m.scanner.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+// expect receives frames until one of the given type from the given member arrives
+func (m *testMember) expect(t *testing.T, messageType, from string) v1.Message {
+	t.Helper()
+
+	for {
+		if msg := m.receive(t); msg.Type == messageType && msg.From == from {
+			return msg
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRelayFanOut(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+
+	b := joinTestRelay(t, relay, "room", "b")
+	c := joinTestRelay(t, relay, "room", "c")
+	other := joinTestRelay(t, relay, "other", "d")
+
+	// Existing members learn about new ones, and new ones get the joins of the existing ones
+	a.expect(t, v1.TypeJoin, "b")
+	a.expect(t, v1.TypeJoin, "c")
+	c.expect(t, v1.TypeJoin, "a")
+
+	b.send(t, "room", "b", v1.TypePlay)
+
+	a.expect(t, v1.TypePlay, "b")
+	c.expect(t, v1.TypePlay, "b")
+
+	// Rooms are isolated from each other
+	other.send(t, "other", "d", v1.TypePause)
+	a.send(t, "room", "a", v1.TypeSeek)
+
+	c.expect(t, v1.TypeSeek, "a")
+	if msg := b.expect(t, v1.TypeSeek, "a"); msg.Room != "room" {
+		t.Fatalf("received message for room %v", msg.Room)
+	}
+}
+
+func TestRelayRejectsForgedSender(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	b := joinTestRelay(t, relay, "room", "b")
+	a.expect(t, v1.TypeJoin, "b")
+
+	b.send(t, "room", "a", v1.TypePlay)
+
+	// The relay disconnects members that claim to be someone else, so the others only hear that they left
+	a.expect(t, v1.TypeLeave, "b")
+}
+
+func TestRelayChatHistory(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+
+	for i := 0; i < chatHistorySize+5; i++ {
+		a.send(t, "room", "a", v1.TypeChat)
+	}
+	a.send(t, "room", "a", v1.TypePlay)
+
+	waitFor(t, func() bool {
+		relay.roomsLock.Lock()
+		defer relay.roomsLock.Unlock()
+
+		return len(relay.rooms["room"].chat) == chatHistorySize
+	})
+
+	b := joinTestRelay(t, relay, "room", "b")
+
+	b.expect(t, v1.TypeJoin, "a")
+	for i := 0; i < chatHistorySize; i++ {
+		if msg := b.receive(t); msg.Type != v1.TypeChat {
+			t.Fatalf("expected chat history, got %v", msg.Type)
+		}
+	}
+}
+
+func TestRelayReconnectTakeover(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	b := resumeTestRelay(t, relay, "room", "b", "token")
+
+	// The others never get to see the resume token
+	if msg := a.expect(t, v1.TypeJoin, "b"); msg.Token != "" {
+		t.Fatal("received join with resume token")
+	}
+
+	resumed := resumeTestRelay(t, relay, "room", "b", "token")
+	resumed.expect(t, v1.TypeJoin, "a")
+
+	// The old connection is closed in favour of the new one
+	if err := b.conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	for b.scanner.Scan() {
+	}
+
+	resumed.send(t, "room", "b", v1.TypePause)
+
+	// Closing the old connection must not make the others think that the member has left
+	for {
+		msg := a.receive(t)
+		if msg.Type == v1.TypeLeave {
+			t.Fatal("received leave for member that resumed on a new connection")
+		}
+
+		if msg.Type == v1.TypePause && msg.From == "b" {
+			break
+		}
+	}
+
+	if members := relay.Rooms()["room"]; len(members) != 2 {
+		t.Fatalf("expected 2 members, got %v", members)
+	}
+}
+
+func TestRelayRejectsTakeover(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := resumeTestRelay(t, relay, "room", "a", "token")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+
+	for _, resumeToken := range []string{"", "other"} {
+		impostor := resumeTestRelay(t, relay, "room", "a", resumeToken)
+
+		// Connections that can't prove that they belong to the member are closed before they see anything
+		if err := impostor.conn.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+			t.Fatal(err)
+		}
+		if impostor.scanner.Scan() {
+			t.Fatalf("impostor with resume token %q received frame", resumeToken)
+		}
+	}
+
+	b := joinTestRelay(t, relay, "room", "b")
+	a.expect(t, v1.TypeJoin, "b")
+
+	// The member keeps its connection
+	b.send(t, "room", "b", v1.TypePlay)
+	a.expect(t, v1.TypePlay, "b")
+}
+
+func TestRelayLeave(t *testing.T) {
+	relay := openTestRelay(t, time.Minute)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	b := joinTestRelay(t, relay, "room", "b")
+	a.expect(t, v1.TypeJoin, "b")
+
+	// Members that drop without saying goodbye are announced as gone by the relay
+	_ = b.conn.Close()
+
+	a.expect(t, v1.TypeLeave, "b")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+}
+
+func TestRelayRoomExpiry(t *testing.T) {
+	relay := openTestRelay(t, time.Millisecond*100)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+
+	// Rooms with members are kept around
+	time.Sleep(time.Millisecond * 300)
+	if _, ok := relay.Rooms()["room"]; !ok {
+		t.Fatal("room with members expired")
+	}
+
+	a.send(t, "room", "a", v1.TypeLeave)
+
+	waitFor(t, func() bool {
+		_, ok := relay.Rooms()["room"]
+
+		return !ok
+	})
+}
+
+func TestRelayWithoutRoomExpiry(t *testing.T) {
+	relay := openTestRelay(t, 0)
+
+	a := joinTestRelay(t, relay, "room", "a")
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 1 })
+
+	a.send(t, "room", "a", v1.TypeLeave)
+	waitFor(t, func() bool { return len(relay.Rooms()["room"]) == 0 })
+
+	// Empty rooms are kept around if they don't have a TTL
+	time.Sleep(time.Millisecond * 300)
+	if _, ok := relay.Rooms()["room"]; !ok {
+		t.Fatal("room without TTL expired")
+	}
+}
@@ -0,0 +1,65 @@
+package party
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrReplayed = errors.New("message has already been received")
+	ErrExpired  = errors.New("message is older than the replay window")
+)
+
+// ReplayGuard drops messages that have been received before; since senders can't be trusted to have synchronized clocks yet, timestamps are only compared to the latest one of the same sender
+type ReplayGuard struct {
+	window time.Duration
+
+	latest map[string]int64
+	seen   map[string]map[string]int64
+	lock   sync.Mutex
+}
+
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		window: window,
+
+		latest: map[string]int64{},
+		seen:   map[string]map[string]int64{},
+	}
+}
+
+// Check records the nonce of an authenticated message and fails if it has been seen or is too old to tell
+func (g *ReplayGuard) Check(from string, timestamp int64, nonce []byte) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	latest, ok := g.latest[from]
+	if ok && timestamp < latest-g.window.Nanoseconds() {
+		return ErrExpired
+	}
+
+	seen, ok := g.seen[from]
+	if !ok {
+		seen = map[string]int64{}
+		g.seen[from] = seen
+	}
+
+	if _, ok := seen[string(nonce)]; ok {
+		return ErrReplayed
+	}
+	seen[string(nonce)] = timestamp
+
+	if timestamp > latest {
+		g.latest[from] = timestamp
+
+		// Nonces that are outside of the window are rejected by their timestamp already
+		for nonce, t := range seen {
+			if t < timestamp-g.window.Nanoseconds() {
+				delete(seen, nonce)
+			}
+		}
+	}
+
+	return nil
+}
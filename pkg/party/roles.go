@@ -0,0 +1,139 @@
+package party
+
+import (
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+type Roles struct {
+	roles     v1.Roles
+	rolesLock sync.Mutex
+}
+
+func NewRoles(host string) *Roles {
+	return &Roles{
+		roles: v1.Roles{
+			Host:    host,
+			CoHosts: []string{},
+			Kicked:  []string{},
+		},
+	}
+}
+
+func (r *Roles) Get() v1.Roles {
+	r.rolesLock.Lock()
+	defer r.rolesLock.Unlock()
+
+	return v1.Roles{
+		Host:       r.roles.Host,
+		CoHosts:    append([]string{}, r.roles.CoHosts...),
+		Kicked:     append([]string{}, r.roles.Kicked...),
+		Restricted: r.roles.Restricted,
+	}
+}
+
+func (r *Roles) Set(roles v1.Roles) {
+	r.rolesLock.Lock()
+	defer r.rolesLock.Unlock()
+
+	// The host can't be changed by incoming messages
+	roles.Host = r.roles.Host
+
+	r.roles = roles
+}
+
+func (r *Roles) Role(member string) string {
+	r.rolesLock.Lock()
+	defer r.rolesLock.Unlock()
+
+	return r.role(member)
+}
+
+func (r *Roles) role(member string) string {
+	if member == r.roles.Host {
+		return v1.RoleHost
+	}
+
+	if contains(r.roles.CoHosts, member) {
+		return v1.RoleCoHost
+	}
+
+	return v1.RoleGuest
+}
+
+func (r *Roles) CanControl(member string) bool {
+	r.rolesLock.Lock()
+	defer r.rolesLock.Unlock()
+
+	if contains(r.roles.Kicked, member) {
+		return false
+	}
+
+	return !r.roles.Restricted || r.role(member) != v1.RoleGuest
+}
+
+func (r *Roles) IsKicked(member string) bool {
+	r.rolesLock.Lock()
+	defer r.rolesLock.Unlock()
+
+	return contains(r.roles.Kicked, member)
+}
+
+func (r *Roles) Promote(member string) v1.Roles {
+	r.rolesLock.Lock()
+	if member != r.roles.Host && !contains(r.roles.CoHosts, member) {
+		r.roles.CoHosts = append(r.roles.CoHosts, member)
+	}
+	r.rolesLock.Unlock()
+
+	return r.Get()
+}
+
+func (r *Roles) Demote(member string) v1.Roles {
+	r.rolesLock.Lock()
+	r.roles.CoHosts = remove(r.roles.CoHosts, member)
+	r.rolesLock.Unlock()
+
+	return r.Get()
+}
+
+func (r *Roles) Kick(member string) v1.Roles {
+	r.rolesLock.Lock()
+	if member != r.roles.Host && !contains(r.roles.Kicked, member) {
+		r.roles.CoHosts = remove(r.roles.CoHosts, member)
+		r.roles.Kicked = append(r.roles.Kicked, member)
+	}
+	r.rolesLock.Unlock()
+
+	return r.Get()
+}
+
+func (r *Roles) SetRestricted(restricted bool) v1.Roles {
+	r.rolesLock.Lock()
+	r.roles.Restricted = restricted
+	r.rolesLock.Unlock()
+
+	return r.Get()
+}
+
+func contains(members []string, member string) bool {
+	for _, m := range members {
+		if m == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remove(members []string, member string) []string {
+	rv := []string{}
+	for _, m := range members {
+		if m != member {
+			rv = append(rv, m)
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,80 @@
+package party
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+// Scheduler starts playback at a time on the party clock; scheduling again replaces the previous schedule
+type Scheduler struct {
+	schedule     v1.Schedule
+	timer        *time.Timer
+	scheduleLock sync.Mutex
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Schedule calls `start` once the clock reaches the scheduled time; `start` is called on its own goroutine
+func (s *Scheduler) Schedule(schedule v1.Schedule, clock *Clock, start func()) {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+
+	s.cancel()
+
+	if schedule.At == 0 {
+		return
+	}
+
+	s.schedule = schedule
+
+	var timer *time.Timer
+	timer = clock.AfterFunc(time.Unix(0, schedule.At), func() {
+		s.scheduleLock.Lock()
+		// The schedule has been replaced or cancelled while the timer was firing
+		if s.timer != timer {
+			s.scheduleLock.Unlock()
+
+			return
+		}
+
+		s.schedule = v1.Schedule{}
+		s.timer = nil
+		s.scheduleLock.Unlock()
+
+		start()
+	})
+	s.timer = timer
+}
+
+// Cancel cancels the scheduled playback; it returns false if nothing was scheduled
+func (s *Scheduler) Cancel() bool {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+
+	return s.cancel()
+}
+
+func (s *Scheduler) cancel() bool {
+	if s.timer == nil {
+		return false
+	}
+
+	s.timer.Stop()
+
+	s.schedule = v1.Schedule{}
+	s.timer = nil
+
+	return true
+}
+
+// Get returns the pending schedule; `At` is zero if nothing is scheduled
+func (s *Scheduler) Get() v1.Schedule {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+
+	return s.schedule
+}
@@ -0,0 +1,54 @@
+package party
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+func TestScheduler(t *testing.T) {
+	clock := NewClock(1, time.Now)
+	scheduler := NewScheduler()
+
+	started := make(chan struct{}, 2)
+	start := func() {
+		started <- struct{}{}
+	}
+
+	schedule := v1.Schedule{At: clock.Now().Add(time.Millisecond * 50).UnixNano(), Position: 10}
+	scheduler.Schedule(schedule, clock, start)
+
+	if scheduler.Get() != schedule {
+		t.Fatalf("expected schedule %v, got %v", schedule, scheduler.Get())
+	}
+
+	select {
+	case <-started:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for scheduled playback")
+	}
+
+	if scheduler.Get().At != 0 {
+		t.Fatal("schedule is still pending after playback started")
+	}
+
+	// Cancelled and replaced schedules don't start playback
+	scheduler.Schedule(v1.Schedule{At: clock.Now().Add(time.Millisecond * 50).UnixNano()}, clock, start)
+	if !scheduler.Cancel() {
+		t.Fatal("could not cancel schedule")
+	}
+
+	scheduler.Schedule(v1.Schedule{At: clock.Now().Add(time.Millisecond * 50).UnixNano()}, clock, start)
+	scheduler.Schedule(v1.Schedule{}, clock, start)
+
+	select {
+	case <-started:
+		t.Fatal("cancelled schedule started playback")
+	case <-time.After(testQuietPeriod):
+	}
+
+	if scheduler.Cancel() {
+		t.Fatal("cancelled schedule is still pending")
+	}
+}
@@ -0,0 +1,189 @@
+package party
+
+import (
+	"fmt"
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+// Session tracks a party from the point of view of one of its members, i.e. who is in it and what is waiting for the host's approval
+type Session struct {
+	id   string
+	host string
+
+	roles     *Roles
+	barrier   *Barrier
+	presences *Presences
+
+	members     map[string]string
+	seenChat    map[string]struct{}
+	requests    map[string]v1.Request
+	suggestions map[string]v1.QueueItem
+	stateLock   sync.Mutex
+}
+
+func NewSession(id, host string) *Session {
+	return &Session{
+		id:   id,
+		host: host,
+
+		roles:     NewRoles(host),
+		barrier:   NewBarrier(),
+		presences: NewPresences(),
+
+		members:     map[string]string{},
+		seenChat:    map[string]struct{}{},
+		requests:    map[string]v1.Request{},
+		suggestions: map[string]v1.QueueItem{},
+	}
+}
+
+func (s *Session) IsHost() bool {
+	return s.id == s.host
+}
+
+func (s *Session) Roles() *Roles {
+	return s.roles
+}
+
+func (s *Session) Barrier() *Barrier {
+	return s.barrier
+}
+
+func (s *Session) Presences() *Presences {
+	return s.presences
+}
+
+// Accept returns false if the sender of the message isn't allowed to send it to us
+func (s *Session) Accept(msg v1.Message) bool {
+	if s.roles.IsKicked(msg.From) {
+		return false
+	}
+
+	switch msg.Type {
+	case v1.TypePlay, v1.TypePause, v1.TypeSeek, v1.TypeSelectSubtitles, v1.TypeSelectMedia, v1.TypeSchedule:
+		return s.roles.CanControl(msg.From)
+	case v1.TypeRoles, v1.TypeKick, v1.TypeSnapshot, v1.TypePoll, v1.TypeQueue, v1.TypePosition:
+		return msg.From == s.host
+	case v1.TypeSuggestion, v1.TypeBallot, v1.TypeRequest:
+		return s.IsHost()
+	}
+
+	return true
+}
+
+// Join adds the member to the session; it returns false if the member was already in it, i.e. if it has reconnected
+func (s *Session) Join(member, name string) bool {
+	s.stateLock.Lock()
+	_, rejoined := s.members[member]
+	s.members[member] = name
+	s.stateLock.Unlock()
+
+	s.barrier.Set(member, false)
+
+	return !rejoined
+}
+
+// Leave removes the member from the session and returns its name; it returns false if the member wasn't in it
+func (s *Session) Leave(member string) (string, bool) {
+	s.stateLock.Lock()
+	name, ok := s.members[member]
+	delete(s.members, member)
+	s.stateLock.Unlock()
+
+	s.barrier.Remove(member)
+	s.presences.Remove(member)
+
+	return name, ok
+}
+
+func (s *Session) Name(member string) (string, bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	name, ok := s.members[member]
+
+	return name, ok
+}
+
+// Members returns the names of all other members by their IDs
+func (s *Session) Members() map[string]string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	members := map[string]string{}
+	for member, name := range s.members {
+		members[member] = name
+	}
+
+	return members
+}
+
+// SeeChat returns false if the chat message has been seen before; the relay replays its chat history when reconnecting
+func (s *Session) SeeChat(msg v1.Message) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	key := fmt.Sprintf("%v\x00%v", msg.From, msg.Timestamp)
+	if _, ok := s.seenChat[key]; ok {
+		return false
+	}
+	s.seenChat[key] = struct{}{}
+
+	return true
+}
+
+// AddRequest keeps the request until the host approves it and returns its ID
+func (s *Session) AddRequest(msg v1.Message, request v1.Request) string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	id := getPendingID(msg)
+	s.requests[id] = request
+
+	return id
+}
+
+// TakeRequest removes the request and returns it; it returns false if the request has already been approved
+func (s *Session) TakeRequest(id string) (v1.Request, bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	request, ok := s.requests[id]
+	delete(s.requests, id)
+
+	return request, ok
+}
+
+// AddSuggestion keeps the suggestion as a queue item until the host adds it to the queue
+func (s *Session) AddSuggestion(msg v1.Message, suggestion v1.Suggestion) v1.QueueItem {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	item := v1.QueueItem{
+		ID:          getPendingID(msg),
+		Magnet:      suggestion.Magnet,
+		Path:        suggestion.Path,
+		Title:       suggestion.Title,
+		SuggestedBy: s.members[msg.From],
+	}
+	s.suggestions[item.ID] = item
+
+	return item
+}
+
+// TakeSuggestion removes the suggestion and returns it; it returns false if the suggestion has already been added
+func (s *Session) TakeSuggestion(id string) (v1.QueueItem, bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	item, ok := s.suggestions[id]
+	delete(s.suggestions, id)
+
+	return item, ok
+}
+
+func getPendingID(msg v1.Message) string {
+	return fmt.Sprintf("%v-%v", msg.From, msg.Timestamp)
+}
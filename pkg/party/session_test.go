@@ -0,0 +1,115 @@
+package party
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+func TestSessionAccept(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		from     string
+		kind     string
+		expected bool
+	}{
+		{"chat from guest", "guest", "other", v1.TypeChat, true},
+		{"play from guest", "host", "guest", v1.TypePlay, true},
+		{"play from restricted guest", "host", "restricted", v1.TypePlay, false},
+		{"play from co-host", "host", "cohost", v1.TypePlay, true},
+		{"chat from kicked member", "host", "kicked", v1.TypeChat, false},
+		{"roles from host", "guest", testReference, v1.TypeRoles, true},
+		{"roles from co-host", "guest", "cohost", v1.TypeRoles, false},
+		{"position from guest", "guest", "other", v1.TypePosition, false},
+		{"snapshot from host", "guest", testReference, v1.TypeSnapshot, true},
+		{"suggestion to host", testReference, "guest", v1.TypeSuggestion, true},
+		{"suggestion to guest", "guest", "other", v1.TypeSuggestion, false},
+		{"ballot to guest", "guest", "other", v1.TypeBallot, false},
+		{"request to guest", "guest", "other", v1.TypeRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := NewSession(tt.id, testReference)
+			session.Roles().Set(v1.Roles{
+				CoHosts:    []string{"cohost"},
+				Kicked:     []string{"kicked"},
+				Restricted: tt.from == "restricted",
+			})
+
+			if accepted := session.Accept(v1.Message{Type: tt.kind, From: tt.from}); accepted != tt.expected {
+				t.Fatalf("expected accepted to be %v, got %v", tt.expected, accepted)
+			}
+		})
+	}
+}
+
+func TestSessionMembers(t *testing.T) {
+	session := NewSession(testReference, testReference)
+
+	if !session.Join("guest", "Guest") {
+		t.Fatal("first join isn't reported as new")
+	}
+
+	// Members that reconnect announce themselves again
+	if session.Join("guest", "Guest") {
+		t.Fatal("reconnect is reported as new join")
+	}
+
+	if waiting := session.Barrier().Waiting(); len(waiting) != 1 || waiting[0] != "guest" {
+		t.Fatalf("expected guest to be waiting, got %v", waiting)
+	}
+
+	session.Presences().Set("guest", v1.Presence{}, time.Now())
+
+	if name, ok := session.Leave("guest"); !ok || name != "Guest" {
+		t.Fatalf("expected Guest to leave, got %v", name)
+	}
+
+	if _, ok := session.Leave("guest"); ok {
+		t.Fatal("member left twice")
+	}
+
+	if len(session.Members()) != 0 || len(session.Barrier().Waiting()) != 0 {
+		t.Fatal("member that left is still in the party")
+	}
+
+	if _, _, ok := session.Presences().Get("guest"); ok {
+		t.Fatal("presence of member that left is still tracked")
+	}
+}
+
+func TestSessionPending(t *testing.T) {
+	session := NewSession(testReference, testReference)
+	session.Join("guest", "Guest")
+
+	msg := v1.Message{From: "guest", Timestamp: 1}
+
+	if !session.SeeChat(msg) || session.SeeChat(msg) {
+		t.Fatal("replayed chat message isn't detected")
+	}
+
+	item := session.AddSuggestion(msg, v1.Suggestion{Magnet: "magnet", Path: "Movie.mkv"})
+	if item.SuggestedBy != "Guest" {
+		t.Fatalf("expected suggestion by Guest, got %v", item.SuggestedBy)
+	}
+
+	if taken, ok := session.TakeSuggestion(item.ID); !ok || taken.Path != "Movie.mkv" {
+		t.Fatal("could not take suggestion")
+	}
+
+	if _, ok := session.TakeSuggestion(item.ID); ok {
+		t.Fatal("suggestion was added twice")
+	}
+
+	id := session.AddRequest(msg, v1.Request{Type: v1.TypePlay})
+	if request, ok := session.TakeRequest(id); !ok || request.Type != v1.TypePlay {
+		t.Fatal("could not take request")
+	}
+
+	if _, ok := session.TakeRequest(id); ok {
+		t.Fatal("request was approved twice")
+	}
+}
@@ -0,0 +1,98 @@
+package party
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Transport delivers frames (serialized messages) between the members of a party
+type Transport interface {
+	Open() error
+	// Send delivers the frame to all other members of the room
+	Send(frame []byte) error
+	// Receive blocks until the next frame arrives; it returns `net.ErrClosed` once the transport has been closed
+	Receive() ([]byte, error)
+	Close() error
+}
+
+// RelayTransport sends all frames through a relay
+type RelayTransport struct {
+	raddr string
+
+	conn     net.Conn
+	connLock sync.Mutex
+	scanner  *bufio.Scanner
+
+	ctx context.Context
+}
+
+func NewRelayTransport(
+	raddr string,
+
+	ctx context.Context,
+) *RelayTransport {
+	return &RelayTransport{
+		raddr: raddr,
+
+		ctx: ctx,
+	}
+}
+
+func (t *RelayTransport) Open() error {
+	log.Trace().Msg("Opening relay transport")
+
+	var d net.Dialer
+	conn, err := d.DialContext(t.ctx, "tcp", t.raddr)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+
+	t.scanner = bufio.NewScanner(conn)
+	t.scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return nil
+}
+
+func (t *RelayTransport) Send(frame []byte) error {
+	if t.conn == nil {
+		return ErrPartyNotOpen
+	}
+
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+
+	_, err := t.conn.Write(append(append([]byte{}, frame...), '\n'))
+
+	return err
+}
+
+func (t *RelayTransport) Receive() ([]byte, error) {
+	if t.scanner == nil {
+		return nil, ErrPartyNotOpen
+	}
+
+	if !t.scanner.Scan() {
+		if err := t.scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, net.ErrClosed
+	}
+
+	return append([]byte{}, t.scanner.Bytes()...), nil
+}
+
+func (t *RelayTransport) Close() error {
+	log.Trace().Msg("Closing relay transport")
+
+	if t.conn == nil {
+		return nil
+	}
+
+	return t.conn.Close()
+}
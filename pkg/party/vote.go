@@ -0,0 +1,141 @@
+package party
+
+import (
+	"sync"
+
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+)
+
+// Vote tracks a poll on what to watch next; the host counts the ballots and everyone else mirrors its tallies
+type Vote struct {
+	poll     v1.Poll
+	pollLock sync.Mutex
+}
+
+func NewVote() *Vote {
+	return &Vote{
+		poll: v1.Poll{
+			Candidates: []string{},
+			Ballots:    map[string]string{},
+			Closed:     true,
+		},
+	}
+}
+
+func (v *Vote) Get() v1.Poll {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	return v.get()
+}
+
+func (v *Vote) get() v1.Poll {
+	ballots := map[string]string{}
+	for member, candidate := range v.poll.Ballots {
+		ballots[member] = candidate
+	}
+
+	return v1.Poll{
+		ID:         v.poll.ID,
+		Candidates: append([]string{}, v.poll.Candidates...),
+		Ballots:    ballots,
+		Closed:     v.poll.Closed,
+	}
+}
+
+func (v *Vote) Set(poll v1.Poll) {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	if poll.Candidates == nil {
+		poll.Candidates = []string{}
+	}
+
+	if poll.Ballots == nil {
+		poll.Ballots = map[string]string{}
+	}
+
+	v.poll = poll
+}
+
+// Open starts a new poll, discarding the ballots of the previous one
+func (v *Vote) Open(id string, candidates []string) v1.Poll {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	v.poll = v1.Poll{
+		ID:         id,
+		Candidates: append([]string{}, candidates...),
+		Ballots:    map[string]string{},
+	}
+
+	return v.get()
+}
+
+func (v *Vote) Close() v1.Poll {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	v.poll.Closed = true
+
+	return v.get()
+}
+
+// Cast records the member's ballot, replacing its previous one; it returns false if the ballot is not for a candidate of the open poll
+func (v *Vote) Cast(member string, ballot v1.Ballot) bool {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	if v.poll.Closed || ballot.Poll != v.poll.ID || !contains(v.poll.Candidates, ballot.Candidate) {
+		return false
+	}
+
+	v.poll.Ballots[member] = ballot.Candidate
+
+	return true
+}
+
+// Remove discards the member's ballot, i.e. because it left the party
+func (v *Vote) Remove(member string) bool {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	if _, ok := v.poll.Ballots[member]; !ok {
+		return false
+	}
+
+	delete(v.poll.Ballots, member)
+
+	return true
+}
+
+func (v *Vote) Tally() map[string]int {
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	tally := map[string]int{}
+	for _, candidate := range v.poll.Ballots {
+		tally[candidate]++
+	}
+
+	return tally
+}
+
+// Winner returns the candidate with the most votes; ties go to the candidate that comes first
+func (v *Vote) Winner() (string, bool) {
+	tally := v.Tally()
+
+	v.pollLock.Lock()
+	defer v.pollLock.Unlock()
+
+	winner := ""
+	votes := 0
+	for _, candidate := range v.poll.Candidates {
+		if tally[candidate] > votes {
+			winner = candidate
+			votes = tally[candidate]
+		}
+	}
+
+	return winner, votes > 0
+}
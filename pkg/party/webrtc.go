@@ -0,0 +1,498 @@
+package party
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	v1 "github.com/pojntfx/vintangle/pkg/api/party/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dataChannelLabel = "party"
+
+	webRTCTransportBufferSize = 128
+)
+
+var (
+	ErrUnknownPeer = errors.New("could not find peer")
+	ErrPeerExists  = errors.New("already connected to peer")
+)
+
+type peer struct {
+	conn    *webrtc.PeerConnection
+	channel *webrtc.DataChannel
+	open    bool
+}
+
+// WebRTCTransport sends frames directly to the other members over WebRTC data channels; the underlying transport is used for signaling and whenever a direct connection to a member can't be established
+type WebRTCTransport struct {
+	signaling  Transport
+	room       string
+	id         string
+	keyring    *Keyring
+	iceServers []string
+
+	replays *ReplayGuard
+
+	members   map[string]struct{}
+	peers     map[string]*peer
+	peersLock sync.Mutex
+
+	frames chan []byte
+	errs   chan error
+	done   chan struct{}
+
+	closeOnce sync.Once
+
+	ctx context.Context
+}
+
+func NewWebRTCTransport(
+	signaling Transport,
+	room string,
+	id string,
+	keyring *Keyring,
+	iceServers []string,
+
+	ctx context.Context,
+) *WebRTCTransport {
+	return &WebRTCTransport{
+		signaling:  signaling,
+		room:       room,
+		id:         id,
+		keyring:    keyring,
+		iceServers: iceServers,
+
+		replays: NewReplayGuard(replayWindow),
+
+		members: map[string]struct{}{},
+		peers:   map[string]*peer{},
+
+		frames: make(chan []byte, webRTCTransportBufferSize),
+		errs:   make(chan error, 1),
+		done:   make(chan struct{}),
+
+		ctx: ctx,
+	}
+}
+
+func (t *WebRTCTransport) Open() error {
+	log.Trace().Msg("Opening WebRTC transport")
+
+	if err := t.signaling.Open(); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			frame, err := t.signaling.Receive()
+			if err != nil {
+				t.errs <- err
+
+				return
+			}
+
+			var msg v1.Message
+			if err := json.Unmarshal(frame, &msg); err != nil {
+				log.Debug().
+					Err(err).
+					Msg("Could not parse frame, skipping")
+
+				continue
+			}
+
+			// Our own heartbeats are acknowledgements from the relay
+			if msg.From == t.id && msg.Type != v1.TypeHeartbeat {
+				continue
+			}
+
+			switch msg.Type {
+			case v1.TypeSignal:
+				if err := t.handleSignal(msg); err != nil {
+					log.Debug().
+						Str("from", msg.From).
+						Err(err).
+						Msg("Could not handle signal, skipping")
+				}
+
+				continue
+			case v1.TypeJoin:
+				t.addMember(msg.From)
+			case v1.TypeLeave:
+				t.removeMember(msg.From)
+			}
+
+			t.receive(frame)
+		}
+	}()
+
+	return nil
+}
+
+func (t *WebRTCTransport) receive(frame []byte) {
+	select {
+	case t.frames <- frame:
+	case <-t.done:
+	}
+}
+
+func (t *WebRTCTransport) addMember(member string) {
+	t.peersLock.Lock()
+	defer t.peersLock.Unlock()
+
+	// Members only announce themselves again if they reconnected with a new transport, so our connection to them is stale
+	if _, ok := t.members[member]; ok {
+		t.closePeer(member)
+	}
+	t.members[member] = struct{}{}
+
+	// Only one side of each pair may offer a connection
+	if t.id > member {
+		return
+	}
+
+	go func() {
+		if err := t.connect(member); err != nil {
+			log.Debug().
+				Str("member", member).
+				Err(err).
+				Msg("Could not connect to member directly, relaying messages instead")
+		}
+	}()
+}
+
+func (t *WebRTCTransport) removeMember(member string) {
+	t.peersLock.Lock()
+	defer t.peersLock.Unlock()
+
+	delete(t.members, member)
+
+	t.closePeer(member)
+}
+
+// closePeer must be called with peersLock held
+func (t *WebRTCTransport) closePeer(member string) {
+	p, ok := t.peers[member]
+	if !ok {
+		return
+	}
+
+	delete(t.peers, member)
+
+	go func() {
+		if err := p.conn.Close(); err != nil {
+			log.Debug().
+				Str("member", member).
+				Err(err).
+				Msg("Could not close peer connection")
+		}
+	}()
+}
+
+func (t *WebRTCTransport) newPeer(member string) (*webrtc.PeerConnection, error) {
+	config := webrtc.Configuration{}
+	if len(t.iceServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{
+			{
+				URLs: t.iceServers,
+			},
+		}
+	}
+
+	conn, err := webrtc.NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer{
+		conn: conn,
+	}
+
+	conn.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Debug().
+			Str("member", member).
+			Str("state", state.String()).
+			Msg("Peer connection state changed")
+
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			t.peersLock.Lock()
+			defer t.peersLock.Unlock()
+
+			if t.peers[member] == p {
+				t.closePeer(member)
+			}
+		}
+	})
+
+	conn.OnDataChannel(func(channel *webrtc.DataChannel) {
+		t.setupChannel(member, p, channel)
+	})
+
+	t.peersLock.Lock()
+	defer t.peersLock.Unlock()
+
+	t.closePeer(member)
+	t.peers[member] = p
+
+	return conn, nil
+}
+
+func (t *WebRTCTransport) setupChannel(member string, p *peer, channel *webrtc.DataChannel) {
+	channel.OnOpen(func() {
+		log.Info().
+			Str("member", member).
+			Msg("Connected to member directly")
+
+		t.peersLock.Lock()
+		defer t.peersLock.Unlock()
+
+		p.channel = channel
+		p.open = true
+	})
+
+	channel.OnClose(func() {
+		t.peersLock.Lock()
+		defer t.peersLock.Unlock()
+
+		p.open = false
+	})
+
+	channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		t.receive(msg.Data)
+	})
+}
+
+func (t *WebRTCTransport) connect(member string) error {
+	conn, err := t.newPeer(member)
+	if err != nil {
+		return err
+	}
+
+	t.peersLock.Lock()
+	p := t.peers[member]
+	t.peersLock.Unlock()
+
+	channel, err := conn.CreateDataChannel(dataChannelLabel, nil)
+	if err != nil {
+		return err
+	}
+	t.setupChannel(member, p, channel)
+
+	offer, err := conn.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+
+	return t.signal(member, conn, offer)
+}
+
+// signal waits for all ICE candidates to be gathered and sends the resulting description to the member
+func (t *WebRTCTransport) signal(member string, conn *webrtc.PeerConnection, description webrtc.SessionDescription) error {
+	gathered := webrtc.GatheringCompletePromise(conn)
+
+	if err := conn.SetLocalDescription(description); err != nil {
+		return err
+	}
+
+	select {
+	case <-gathered:
+	case <-t.done:
+		return net.ErrClosed
+	}
+
+	rawSignal, err := json.Marshal(v1.Signal{
+		To:      member,
+		SDPType: conn.LocalDescription().Type.String(),
+		SDP:     conn.LocalDescription().SDP,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := v1.Message{
+		Version:   v1.Version,
+		Type:      v1.TypeSignal,
+		Room:      t.room,
+		From:      t.id,
+		Timestamp: time.Now().UnixNano(),
+	}
+
+	// Descriptions contain our addresses, and forged ones could redirect our traffic, so they are sealed like all other messages
+	sealed, err := t.keyring.Seal(t.keyring.Epoch(), rawSignal, getAdditionalData(msg))
+	if err != nil {
+		return err
+	}
+
+	msg.Payload, err = json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return t.signaling.Send(frame)
+}
+
+func (t *WebRTCTransport) handleSignal(msg v1.Message) error {
+	var sealed v1.Sealed
+	if err := json.Unmarshal(msg.Payload, &sealed); err != nil {
+		return err
+	}
+
+	rawSignal, err := t.keyring.Open(sealed, getAdditionalData(msg), false)
+	if err != nil {
+		return err
+	}
+
+	if err := t.replays.Check(msg.From, msg.Timestamp, sealed.Nonce); err != nil {
+		return err
+	}
+
+	var signal v1.Signal
+	if err := json.Unmarshal(rawSignal, &signal); err != nil {
+		return err
+	}
+
+	if signal.To != t.id {
+		return nil
+	}
+
+	description := webrtc.SessionDescription{
+		Type: webrtc.NewSDPType(signal.SDPType),
+		SDP:  signal.SDP,
+	}
+
+	switch description.Type {
+	case webrtc.SDPTypeOffer:
+		// Replacing a working connection would drop the frames that are in flight on it
+		t.peersLock.Lock()
+		p, ok := t.peers[msg.From]
+		t.peersLock.Unlock()
+
+		if ok {
+			switch p.conn.ConnectionState() {
+			case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+			default:
+				return ErrPeerExists
+			}
+		}
+
+		conn, err := t.newPeer(msg.From)
+		if err != nil {
+			return err
+		}
+
+		if err := conn.SetRemoteDescription(description); err != nil {
+			return err
+		}
+
+		answer, err := conn.CreateAnswer(nil)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := t.signal(msg.From, conn, answer); err != nil {
+				log.Debug().
+					Str("member", msg.From).
+					Err(err).
+					Msg("Could not answer member, relaying messages instead")
+			}
+		}()
+
+		return nil
+	case webrtc.SDPTypeAnswer:
+		t.peersLock.Lock()
+		p, ok := t.peers[msg.From]
+		t.peersLock.Unlock()
+
+		if !ok {
+			return ErrUnknownPeer
+		}
+
+		return p.conn.SetRemoteDescription(description)
+	}
+
+	return nil
+}
+
+// Send delivers the frame directly if every member can be reached that way and through the relay otherwise. Frames
+// are only kept in order on the same path, so a frame that is relayed (i.e. a chat message, or any frame while a member is
+// connecting) can arrive before or after the frames that were sent directly around the same time.
+func (t *WebRTCTransport) Send(frame []byte) error {
+	var msg v1.Message
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return err
+	}
+
+	// The relay needs to see these messages to manage the room and its chat history
+	switch msg.Type {
+	case v1.TypeJoin, v1.TypeLeave, v1.TypeHeartbeat, v1.TypeChat:
+		return t.signaling.Send(frame)
+	}
+
+	t.peersLock.Lock()
+	channels := []*webrtc.DataChannel{}
+	for member := range t.members {
+		p, ok := t.peers[member]
+		if !ok || !p.open {
+			channels = nil
+
+			break
+		}
+
+		channels = append(channels, p.channel)
+	}
+	t.peersLock.Unlock()
+
+	// Since the relay can only broadcast, fall back to it unless we can reach every member directly
+	if len(channels) == 0 {
+		return t.signaling.Send(frame)
+	}
+
+	for _, channel := range channels {
+		if err := channel.Send(frame); err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Could not send frame directly, relaying it instead")
+
+			// Some members might receive the frame twice, which is better than not at all; the client drops the duplicate
+			return t.signaling.Send(frame)
+		}
+	}
+
+	return nil
+}
+
+func (t *WebRTCTransport) Receive() ([]byte, error) {
+	select {
+	case frame := <-t.frames:
+		return frame, nil
+	case err := <-t.errs:
+		return nil, err
+	}
+}
+
+func (t *WebRTCTransport) Close() error {
+	log.Trace().Msg("Closing WebRTC transport")
+
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+
+	t.peersLock.Lock()
+	for member := range t.peers {
+		t.closePeer(member)
+	}
+	t.peersLock.Unlock()
+
+	return t.signaling.Close()
+}
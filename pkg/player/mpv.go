@@ -0,0 +1,397 @@
+package player
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pojntfx/vintangle/pkg/mpv"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	mpvDefaultSubtitleFont = "sans-serif"
+)
+
+var (
+	mpvTrackProperties = map[string]string{
+		TrackTypeVideo:     "vid",
+		TrackTypeAudio:     "aid",
+		TrackTypeSubtitles: "sid",
+	}
+)
+
+// MPV plays media in mpv, which it controls over its JSON IPC socket
+type MPV struct {
+	command string
+	timeout time.Duration
+
+	ipcDir  string
+	process *exec.Cmd
+	exited  chan error
+	closed  bool
+
+	client *mpv.Client
+	store  *mpv.Store
+
+	subscribers     []func(previous, current State)
+	subscribersLock sync.Mutex
+
+	ctx context.Context
+}
+
+// FindWorkingMPV returns a command that can launch mpv, preferring a system installation over Flatpak
+func FindWorkingMPV() (string, error) {
+	if _, err := os.Stat("/.flatpak-info"); err == nil {
+		return findWorkingCommand([][]string{
+			{"flatpak-spawn", "--host", "mpv"},
+			{"flatpak-spawn", "--host", "flatpak", "run", "io.mpv.Mpv"},
+		}, "--version")
+	}
+
+	return findWorkingCommand([][]string{
+		{"mpv"},
+		{"flatpak", "run", "io.mpv.Mpv"},
+	}, "--version")
+}
+
+func NewMPV(
+	command string,
+	timeout time.Duration,
+
+	ctx context.Context,
+) *MPV {
+	return &MPV{
+		command: command,
+		timeout: timeout,
+
+		ctx: ctx,
+	}
+}
+
+func (p *MPV) Launch(url, username, password string) error {
+	log.Trace().Msg("Launching mpv")
+
+	ipcDir, err := os.MkdirTemp(os.TempDir(), "mpv-ipc")
+	if err != nil {
+		return err
+	}
+	p.ipcDir = ipcDir
+
+	ipcFile := filepath.Join(ipcDir, "mpv.sock")
+	usernameAndPassword := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", username, password)))
+
+	process, err := startCommand(fmt.Sprintf("%v '--keep-open=always' '--no-osc' '--no-input-default-bindings' '--pause' '--input-ipc-server=%v' '--http-header-fields=Authorization: Basic %v' '%v'", p.command, ipcFile, usernameAndPassword, url))
+	if err != nil {
+		return err
+	}
+	p.process = process
+
+	p.exited = make(chan error, 1)
+	go func() {
+		p.exited <- process.Wait()
+	}()
+
+	// mpv creates the IPC socket some time after it has started
+	for {
+		err := p.Connect(ipcFile)
+		if err == nil {
+			return nil
+		}
+
+		log.Debug().
+			Str("path", ipcFile).
+			Err(err).
+			Msg("Could not connect to mpv, retrying in 100ms")
+
+		select {
+		case <-time.After(time.Millisecond * 100):
+		case err := <-p.exited:
+			p.exited <- err
+
+			if err != nil {
+				return err
+			}
+
+			return ErrPlayerExited
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		}
+	}
+}
+
+// Connect controls an mpv that is already running, i.e. a fake one, without launching it
+func (p *MPV) Connect(ipcFile string) error {
+	client := mpv.NewClient(ipcFile, p.timeout, p.ctx)
+	if err := client.Open(); err != nil {
+		return err
+	}
+
+	store := mpv.NewStore(client, p.ctx)
+	store.Subscribe(func(previous, current mpv.State) {
+		p.subscribersLock.Lock()
+		subscribers := append([]func(previous, current State){}, p.subscribers...)
+		p.subscribersLock.Unlock()
+
+		for _, subscriber := range subscribers {
+			subscriber(convertMPVState(previous), convertMPVState(current))
+		}
+	})
+
+	if err := store.Open(); err != nil {
+		_ = client.Close()
+
+		return err
+	}
+
+	p.client = client
+	p.store = store
+
+	return nil
+}
+
+func convertMPVState(state mpv.State) State {
+	tracks := []Track{}
+	for _, track := range state.TrackList {
+		tracks = append(tracks, Track{
+			ID:       track.ID,
+			Type:     track.Type,
+			Title:    track.Title,
+			Lang:     track.Lang,
+			Codec:    track.Codec,
+			Selected: track.Selected,
+			Default:  track.Default,
+			Forced:   track.Forced,
+			External: track.External,
+		})
+	}
+
+	return State{
+		Position:      seconds(state.TimePos),
+		Duration:      seconds(state.Duration),
+		Paused:        state.Pause,
+		Volume:        state.Volume / 100,
+		EOFReached:    state.EOFReached,
+		Buffering:     state.PausedForCache,
+		CacheTime:     seconds(state.DemuxerCacheTime),
+		SubtitleDelay: seconds(state.SubDelay),
+		Tracks:        tracks,
+	}
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+func (p *MPV) Wait() error {
+	if p.process == nil {
+		if p.client == nil {
+			return ErrPlayerNotLaunched
+		}
+
+		return p.client.Wait()
+	}
+
+	err := <-p.exited
+	p.exited <- err
+
+	if p.client != nil {
+		if err := p.client.Close(); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not close mpv client")
+		}
+	}
+
+	// mpv has been killed if we closed it
+	if p.closed {
+		return nil
+	}
+
+	return err
+}
+
+func (p *MPV) Close() error {
+	log.Trace().Msg("Closing mpv")
+
+	p.closed = true
+
+	if p.process != nil {
+		if err := killCommand(p.process); err != nil {
+			return err
+		}
+	}
+
+	if p.client != nil {
+		_ = p.client.Close()
+	}
+
+	if p.ipcDir != "" {
+		return os.RemoveAll(p.ipcDir)
+	}
+
+	return nil
+}
+
+func (p *MPV) Subscribe(subscriber func(previous, current State)) {
+	p.subscribersLock.Lock()
+	defer p.subscribersLock.Unlock()
+
+	p.subscribers = append(p.subscribers, subscriber)
+}
+
+func (p *MPV) State() State {
+	if p.store == nil {
+		return State{}
+	}
+
+	return convertMPVState(p.store.Get())
+}
+
+func (p *MPV) SetPaused(paused bool) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.Pause(p.ctx, paused)
+}
+
+func (p *MPV) Seek(position time.Duration) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.Seek(p.ctx, position.Seconds())
+}
+
+func (p *MPV) SeekPrecision() time.Duration {
+	return 0
+}
+
+func (p *MPV) SetVolume(volume float64) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetVolume(p.ctx, volume*100)
+}
+
+func (p *MPV) SetSpeed(speed float64) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetSpeed(p.ctx, speed)
+}
+
+func (p *MPV) SetFullscreen(fullscreen bool) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetFullscreen(p.ctx, fullscreen)
+}
+
+// SetSubtitlesFile replaces the external subtitles and selects them even if an embedded track has been selected before
+func (p *MPV) SetSubtitlesFile(file string) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	if err := p.client.ClearSubFiles(p.ctx); err != nil {
+		return err
+	}
+
+	return p.client.AddSubFile(p.ctx, file)
+}
+
+func (p *MPV) ClearSubtitles() error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.ClearSubFiles(p.ctx)
+}
+
+func (p *MPV) SetSubtitleDelay(delay time.Duration) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetSubDelay(p.ctx, delay.Seconds())
+}
+
+func (p *MPV) SetSubtitleScale(scale float64) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetProperty(p.ctx, "sub-scale", scale)
+}
+
+func (p *MPV) SetSubtitlePosition(position int) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetProperty(p.ctx, "sub-pos", position)
+}
+
+func (p *MPV) SetSubtitleFont(font string) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	if strings.TrimSpace(font) == "" {
+		font = mpvDefaultSubtitleFont
+	}
+
+	return p.client.SetProperty(p.ctx, "sub-font", font)
+}
+
+func (p *MPV) SelectTrack(trackType string, id int) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	property, ok := mpvTrackProperties[trackType]
+	if !ok {
+		return ErrUnsupported
+	}
+
+	if id <= 0 {
+		return p.client.SetProperty(p.ctx, property, "no")
+	}
+
+	return p.client.SetProperty(p.ctx, property, id)
+}
+
+func (p *MPV) ShowText(text string, duration time.Duration) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.ShowText(p.ctx, text, duration)
+}
+
+func (p *MPV) SetOverlay(id int, data string) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.SetOverlay(p.ctx, id, data)
+}
+
+func (p *MPV) RemoveOverlay(id int) error {
+	if p.client == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	return p.client.RemoveOverlay(p.ctx, id)
+}
@@ -0,0 +1,135 @@
+package player
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrPlayerNotLaunched = errors.New("player has not been launched")
+	ErrPlayerExited      = errors.New("player exited before it could be controlled")
+	ErrUnsupported       = errors.New("player does not support this")
+	ErrNoWorkingPlayer   = errors.New("could not find a working player")
+)
+
+const (
+	TrackTypeVideo     = "video"
+	TrackTypeAudio     = "audio"
+	TrackTypeSubtitles = "sub"
+)
+
+// Track is an audio, video or subtitle track of the media
+type Track struct {
+	ID       int
+	Type     string // One of `TrackTypeVideo`, `TrackTypeAudio` or `TrackTypeSubtitles`
+	Title    string
+	Lang     string
+	Codec    string
+	Selected bool
+	Default  bool
+	Forced   bool // Forced subtitles only translate parts of the media, i.e. signs
+	External bool
+}
+
+// State is the playback state of the player
+type State struct {
+	Position      time.Duration
+	Duration      time.Duration // Zero until the media has loaded
+	Paused        bool
+	Volume        float64 // From 0 to 1
+	EOFReached    bool
+	Buffering     bool
+	CacheTime     time.Duration // Position up to which the media has been buffered; players that can't tell report the duration
+	SubtitleDelay time.Duration
+	Tracks        []Track
+}
+
+// Player is a media player that plays a stream in its own window and is controlled remotely
+type Player interface {
+	// Launch starts the player with the stream, authenticating with the username and password
+	Launch(url, username, password string) error
+	// Wait blocks until the player exits; it returns nil if the player has been closed
+	Wait() error
+	Close() error
+
+	// Subscribe calls the subscriber whenever the state has changed; subscribe before launching to receive the initial state
+	Subscribe(subscriber func(previous, current State))
+	State() State
+
+	SetPaused(paused bool) error
+	Seek(position time.Duration) error
+	// SeekPrecision returns the granularity in which the player can seek; zero if it can seek to any position
+	SeekPrecision() time.Duration
+	// SetVolume sets the volume from 0 to 1
+	SetVolume(volume float64) error
+	SetSpeed(speed float64) error
+	SetFullscreen(fullscreen bool) error
+
+	// SetSubtitlesFile replaces the external subtitles with the file at the path
+	SetSubtitlesFile(file string) error
+	ClearSubtitles() error
+	SetSubtitleDelay(delay time.Duration) error
+	SetSubtitleScale(scale float64) error
+	// SetSubtitlePosition moves the subtitles to the position in percent of the screen height, where 100 is the bottom
+	SetSubtitlePosition(position int) error
+	// SetSubtitleFont sets the font family of the subtitles; empty uses the player's default
+	SetSubtitleFont(font string) error
+
+	// SelectTrack switches to the track of the type with the ID; zero disables the tracks of the type
+	SelectTrack(trackType string, id int) error
+
+	// ShowText shows the text on top of the video for the given duration
+	ShowText(text string, duration time.Duration) error
+	// SetOverlay shows ASS events on top of the video in the overlay with the given ID
+	SetOverlay(id int, data string) error
+	RemoveOverlay(id int) error
+}
+
+// findWorkingCommand returns the first of the commands that can be run with the arguments
+func findWorkingCommand(commands [][]string, args ...string) (string, error) {
+	for _, command := range commands {
+		if err := exec.Command(command[0], append(append([]string{}, command[1:]...), args...)...).Run(); err == nil {
+			return strings.Join(command, " "), nil
+		}
+	}
+
+	return "", ErrNoWorkingPlayer
+}
+
+// startCommand runs the command line in a shell since the player command configured in the preferences can have arguments
+func startCommand(commandLine string) (*exec.Cmd, error) {
+	shell := []string{"sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/c"}
+	}
+
+	command := exec.Command(shell[0], append(shell[1:], commandLine)...)
+	if runtime.GOOS != "windows" {
+		command.SysProcAttr = &syscall.SysProcAttr{
+			Setsid: true,
+		}
+	}
+
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+
+	return command, nil
+}
+
+// killCommand kills the command and, since it runs in a shell, the player that it has started
+func killCommand(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+
+	if runtime.GOOS == "windows" {
+		return command.Process.Kill()
+	}
+
+	return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+}
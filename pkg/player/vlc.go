@@ -0,0 +1,808 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+)
+
+const (
+	vlcPollInterval = time.Millisecond * 250
+	vlcMaxVolume    = 256 // VLC's volume for 100%; it goes up to twice that
+
+	vlcStatePaused  = "paused"
+	vlcStateStopped = "stopped"
+
+	vlcStreamPrefix = "Stream "
+
+	vlcChoicesPrefix    = "+----["
+	vlcChoicesEndPrefix = "+----[ end of"
+	vlcChoicePrefix     = "| "
+	vlcChoiceSelected   = " *"
+	vlcPrompt           = "> "
+)
+
+var (
+	ErrVLCInvalidChoice = errors.New("could not parse track from VLC")
+
+	vlcTrackCommands = map[string]string{
+		TrackTypeVideo:     "video_track",
+		TrackTypeAudio:     "audio_track",
+		TrackTypeSubtitles: "subtitle_track",
+	}
+
+	// Commands of VLC's command line interface that list the tracks of a type
+	vlcChoicesCommands = map[string]string{
+		TrackTypeVideo:     "vtrack",
+		TrackTypeAudio:     "atrack",
+		TrackTypeSubtitles: "strack",
+	}
+)
+
+// vlcStream is a stream as listed in VLC's media information
+type vlcStream struct {
+	number int
+	fields map[string]interface{}
+}
+
+// vlcChoice is a track as listed by VLC's command line interface
+type vlcChoice struct {
+	id       int // VLC's ID of the elementary stream
+	text     string
+	selected bool
+}
+
+// vlcStatus is the response of VLC's `/requests/status.json` endpoint
+type vlcStatus struct {
+	State         string      `json:"state"`
+	Time          float64     `json:"time"`
+	Length        float64     `json:"length"`
+	Position      float64     `json:"position"`
+	Volume        float64     `json:"volume"`
+	Fullscreen    interface{} `json:"fullscreen"` // Either a boolean or a number, depending on the version of VLC
+	SubtitleDelay float64     `json:"subtitledelay"`
+	Information   struct {
+		Category jsoniter.RawMessage `json:"category"` // VLC encodes empty objects as arrays, so this is decoded separately
+	} `json:"information"`
+}
+
+// VLC plays media in VLC, which it controls over its HTTP interface; see https://wiki.videolan.org/VLC_HTTP_requests/
+type VLC struct {
+	command string
+	timeout time.Duration
+
+	process  *exec.Cmd
+	exited   chan error
+	closed   bool
+	dir      string
+	addr     string
+	password string
+	client   *http.Client
+
+	rcFile   string
+	rcConn   net.Conn
+	rcReader *bufio.Reader
+	rcLock   sync.Mutex
+
+	state        State
+	status       vlcStatus
+	choices      map[string][]vlcChoice
+	streams      string
+	choicesStale bool
+	stateLock    sync.Mutex
+
+	subscribers     []func(previous, current State)
+	subscribersLock sync.Mutex
+
+	ctx context.Context
+}
+
+// FindWorkingVLC returns a command that can launch VLC, preferring a system installation over Flatpak
+func FindWorkingVLC() (string, error) {
+	if _, err := os.Stat("/.flatpak-info"); err == nil {
+		return findWorkingCommand([][]string{
+			{"flatpak-spawn", "--host", "vlc"},
+			{"flatpak-spawn", "--host", "flatpak", "run", "org.videolan.VLC"},
+		}, "--version")
+	}
+
+	return findWorkingCommand([][]string{
+		{"vlc"},
+		{"flatpak", "run", "org.videolan.VLC"},
+	}, "--version")
+}
+
+func NewVLC(
+	command string,
+	timeout time.Duration,
+
+	ctx context.Context,
+) *VLC {
+	return &VLC{
+		command: command,
+		timeout: timeout,
+
+		client: &http.Client{
+			Timeout: timeout,
+		},
+
+		choices: map[string][]vlcChoice{},
+
+		ctx: ctx,
+	}
+}
+
+func (p *VLC) Launch(streamURL, username, password string) error {
+	log.Trace().Msg("Launching VLC")
+
+	// VLC can't pick a port for its HTTP interface itself, so we have to find a free one
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	p.addr = listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(p.addr)
+	if err != nil {
+		return err
+	}
+
+	rawPassword := make([]byte, 16)
+	if _, err := rand.Read(rawPassword); err != nil {
+		return err
+	}
+	p.password = hex.EncodeToString(rawPassword)
+
+	// Unlike mpv, VLC can't set headers for HTTP streams, but it does support credentials in the URL
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return err
+	}
+	u.User = url.UserPassword(username, password)
+
+	// Other users can see the command line in the process list, so the URL is passed in a playlist that only we can read
+	p.dir, err = os.MkdirTemp("", "vintangle-vlc")
+	if err != nil {
+		return err
+	}
+
+	playlist := filepath.Join(p.dir, "stream.m3u")
+	if err := os.WriteFile(playlist, []byte("#EXTM3U\n"+u.String()+"\n"), 0600); err != nil {
+		_ = os.RemoveAll(p.dir)
+
+		return err
+	}
+
+	// VLC has no other way of taking the password of its HTTP interface, so we pass it in a configuration file that only we can read
+	config := filepath.Join(p.dir, "vlcrc")
+	if err := os.WriteFile(config, []byte("[lua]\nhttp-password="+p.password+"\n"), 0600); err != nil {
+		_ = os.RemoveAll(p.dir)
+
+		return err
+	}
+
+	// The HTTP interface doesn't report the IDs of tracks, but the command line interface does; it is only available over a socket on Unix
+	interfaces := "'--extraintf=http'"
+	if runtime.GOOS != "windows" {
+		p.rcFile = filepath.Join(p.dir, "rc.sock")
+
+		interfaces = fmt.Sprintf("'--extraintf=http:rc' '--rc-unix=%v'", p.rcFile)
+	}
+
+	process, err := startCommand(fmt.Sprintf("%v %v '--http-host=%v' '--http-port=%v' '--config=%v' '--start-paused' '--play-and-pause' '--no-video-title-show' '%v'", p.command, interfaces, host, port, config, playlist))
+	if err != nil {
+		_ = os.RemoveAll(p.dir)
+
+		return err
+	}
+	p.process = process
+
+	p.exited = make(chan error, 1)
+	go func() {
+		p.exited <- process.Wait()
+	}()
+
+	// The HTTP interface becomes available some time after VLC has started
+	for {
+		err := p.poll()
+		if err == nil {
+			break
+		}
+
+		log.Debug().
+			Str("address", p.addr).
+			Err(err).
+			Msg("Could not connect to VLC, retrying in 100ms")
+
+		select {
+		case <-time.After(time.Millisecond * 100):
+		case err := <-p.exited:
+			p.exited <- err
+
+			if err != nil {
+				return err
+			}
+
+			return ErrPlayerExited
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		}
+	}
+
+	// The command line interface becomes available independently of the HTTP interface
+	if p.rcFile != "" {
+		deadline := time.Now().Add(p.timeout)
+		for {
+			err := p.connectRC()
+			if err == nil {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				log.Warn().
+					Err(err).
+					Msg("Could not connect to VLC's command line interface, tracks will not be available until it is available")
+
+				break
+			}
+
+			select {
+			case <-time.After(time.Millisecond * 100):
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		}
+	}
+
+	// VLC's HTTP interface has no events, so we have to poll for changes
+	go func() {
+		t := time.NewTicker(vlcPollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if err := p.poll(); err != nil {
+					log.Debug().
+						Err(err).
+						Msg("Could not poll VLC, retrying")
+				}
+			case err := <-p.exited:
+				p.exited <- err
+
+				return
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// request runs the command with the value and returns VLC's status afterwards; an empty command only returns the status
+func (p *VLC) request(command string, value string) (vlcStatus, error) {
+	if p.process == nil {
+		return vlcStatus{}, ErrPlayerNotLaunched
+	}
+
+	query := url.Values{}
+	if command != "" {
+		query.Set("command", command)
+	}
+	if value != "" {
+		query.Set("val", value)
+	}
+
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, (&url.URL{
+		Scheme:   "http",
+		Host:     p.addr,
+		Path:     "/requests/status.json",
+		RawQuery: strings.ReplaceAll(query.Encode(), "+", "%20"),
+	}).String(), http.NoBody)
+	if err != nil {
+		return vlcStatus{}, err
+	}
+	req.SetBasicAuth("", p.password)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return vlcStatus{}, err
+	}
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+	if res.StatusCode != http.StatusOK {
+		return vlcStatus{}, errors.New(res.Status)
+	}
+
+	var status vlcStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return vlcStatus{}, err
+	}
+
+	return status, nil
+}
+
+func (p *VLC) poll() error {
+	status, err := p.request("", "")
+	if err != nil {
+		return err
+	}
+
+	p.update(status)
+
+	return nil
+}
+
+func (p *VLC) update(status vlcStatus) {
+	length := seconds(status.Length)
+	position := time.Duration(status.Position * float64(length))
+
+	// Streams are missing while VLC is still opening the media
+	category := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(status.Information.Category, &category); err != nil {
+		category = map[string]map[string]interface{}{}
+	}
+
+	streams := map[string][]vlcStream{}
+	names := []string{}
+	for name, fields := range category {
+		if !strings.HasPrefix(name, vlcStreamPrefix) {
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimPrefix(name, vlcStreamPrefix))
+		if err != nil {
+			continue
+		}
+
+		trackType := ""
+		switch fields["Type"] {
+		case "Video":
+			trackType = TrackTypeVideo
+		case "Audio":
+			trackType = TrackTypeAudio
+		case "Subtitle":
+			trackType = TrackTypeSubtitles
+		default:
+			continue
+		}
+
+		streams[trackType] = append(streams[trackType], vlcStream{
+			number: number,
+			fields: fields,
+		})
+		names = append(names, name)
+	}
+
+	// Stream numbers are the order in which VLC has opened the streams
+	for _, typeStreams := range streams {
+		sort.Slice(typeStreams, func(i, j int) bool {
+			return typeStreams[i].number < typeStreams[j].number
+		})
+	}
+
+	sort.Strings(names)
+	p.refreshChoices(strings.Join(names, "\x00"))
+
+	p.stateLock.Lock()
+	choices := p.choices
+	p.stateLock.Unlock()
+
+	tracks := []Track{}
+	for _, trackType := range []string{TrackTypeVideo, TrackTypeAudio, TrackTypeSubtitles} {
+		for i, choice := range choices[trackType] {
+			// VLC's IDs can be zero, which disables tracks
+			track := Track{
+				ID:       choice.id + 1,
+				Type:     trackType,
+				Title:    choice.text,
+				Selected: choice.selected,
+			}
+
+			// VLC lists the tracks in the order in which it has opened the streams; the stream numbers aren't the tracks' IDs
+			if i < len(streams[trackType]) {
+				stream := streams[trackType][i].fields
+
+				if description := stringField(stream, "Description"); description != "" {
+					track.Title = description
+				}
+				track.Lang = stringField(stream, "Language")
+				track.Codec = stringField(stream, "Codec")
+			}
+
+			tracks = append(tracks, track)
+		}
+	}
+
+	current := State{
+		Position: position,
+		Duration: length,
+		Paused:   status.State != "playing",
+		Volume:   status.Volume / vlcMaxVolume,
+		// `--play-and-pause` keeps the last frame open like mpv's `--keep-open`, so we check whether we have paused at the end
+		EOFReached:    length > 0 && (status.State == vlcStateStopped || (status.State == vlcStatePaused && length-position < time.Second)),
+		CacheTime:     length,
+		SubtitleDelay: seconds(status.SubtitleDelay),
+		Tracks:        tracks,
+	}
+
+	p.stateLock.Lock()
+	previous := p.state
+	p.state = current
+	p.status = status
+	p.stateLock.Unlock()
+
+	if reflect.DeepEqual(previous, current) {
+		return
+	}
+
+	p.subscribersLock.Lock()
+	subscribers := append([]func(previous, current State){}, p.subscribers...)
+	p.subscribersLock.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(previous, current)
+	}
+}
+
+// run runs the command and updates the state right away so that subscribers don't have to wait for the next poll
+func (p *VLC) run(command string, value string) error {
+	status, err := p.request(command, value)
+	if err != nil {
+		return err
+	}
+
+	p.update(status)
+
+	return nil
+}
+
+func (p *VLC) connectRC() error {
+	conn, err := net.Dial("unix", p.rcFile)
+	if err != nil {
+		return err
+	}
+
+	p.rcLock.Lock()
+	defer p.rcLock.Unlock()
+
+	if p.rcConn != nil {
+		_ = p.rcConn.Close()
+	}
+
+	p.rcConn = conn
+	p.rcReader = bufio.NewReader(conn)
+
+	return nil
+}
+
+// listChoices lists the tracks of the type with VLC's command line interface
+func (p *VLC) listChoices(trackType string) ([]vlcChoice, error) {
+	p.rcLock.Lock()
+	defer p.rcLock.Unlock()
+
+	if p.rcConn == nil {
+		return nil, ErrPlayerNotLaunched
+	}
+
+	choices, err := p.readChoices(trackType)
+	if err != nil {
+		// We can't tell which response the remaining output belongs to, so we start over with a new connection
+		_ = p.rcConn.Close()
+
+		p.rcConn = nil
+		p.rcReader = nil
+
+		return nil, err
+	}
+
+	return choices, nil
+}
+
+func (p *VLC) readChoices(trackType string) ([]vlcChoice, error) {
+	if err := p.rcConn.SetDeadline(time.Now().Add(p.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.rcConn.Write([]byte(vlcChoicesCommands[trackType] + "\n")); err != nil {
+		return nil, err
+	}
+
+	choices := []vlcChoice{}
+	listing := false
+	for {
+		line, err := p.rcReader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		// The prompt is printed in front of the first line of each response
+		line = strings.TrimRight(line, "\r\n")
+		for strings.HasPrefix(line, vlcPrompt) {
+			line = strings.TrimPrefix(line, vlcPrompt)
+		}
+
+		switch {
+		case strings.HasPrefix(line, vlcChoicesEndPrefix):
+			if listing {
+				return choices, nil
+			}
+		case strings.HasPrefix(line, vlcChoicesPrefix):
+			listing = true
+		case listing && strings.HasPrefix(line, vlcChoicePrefix):
+			choice, err := parseVLCChoice(line)
+			if err != nil {
+				return nil, err
+			}
+
+			// -1 disables the tracks of the type
+			if choice.id >= 0 {
+				choices = append(choices, choice)
+			}
+		}
+	}
+}
+
+// refreshChoices lists the tracks again if the streams have changed since they were last listed
+func (p *VLC) refreshChoices(streams string) {
+	if p.rcFile == "" {
+		return
+	}
+
+	p.stateLock.Lock()
+	if streams == p.streams && !p.choicesStale {
+		p.stateLock.Unlock()
+
+		return
+	}
+	p.stateLock.Unlock()
+
+	p.rcLock.Lock()
+	connected := p.rcConn != nil
+	p.rcLock.Unlock()
+
+	if !connected {
+		if err := p.connectRC(); err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Could not connect to VLC's command line interface, retrying")
+
+			return
+		}
+	}
+
+	choices := map[string][]vlcChoice{}
+	for trackType := range vlcChoicesCommands {
+		typeChoices, err := p.listChoices(trackType)
+		if err != nil {
+			log.Debug().
+				Err(err).
+				Msg("Could not list tracks, retrying")
+
+			return
+		}
+
+		choices[trackType] = typeChoices
+	}
+
+	p.stateLock.Lock()
+	p.choices = choices
+	p.streams = streams
+	p.choicesStale = false
+	p.stateLock.Unlock()
+}
+
+func (p *VLC) Wait() error {
+	if p.process == nil {
+		return ErrPlayerNotLaunched
+	}
+
+	err := <-p.exited
+	p.exited <- err
+
+	// VLC has been killed if we closed it
+	if p.closed {
+		return nil
+	}
+
+	return err
+}
+
+func (p *VLC) Close() error {
+	log.Trace().Msg("Closing VLC")
+
+	p.closed = true
+
+	if p.process == nil {
+		return nil
+	}
+
+	p.rcLock.Lock()
+	if p.rcConn != nil {
+		_ = p.rcConn.Close()
+	}
+	p.rcLock.Unlock()
+
+	if err := killCommand(p.process); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(p.dir)
+}
+
+func (p *VLC) Subscribe(subscriber func(previous, current State)) {
+	p.subscribersLock.Lock()
+	defer p.subscribersLock.Unlock()
+
+	p.subscribers = append(p.subscribers, subscriber)
+}
+
+func (p *VLC) State() State {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	state := p.state
+	state.Tracks = append([]Track{}, p.state.Tracks...)
+
+	return state
+}
+
+func (p *VLC) SetPaused(paused bool) error {
+	if paused {
+		return p.run("pl_forcepause", "")
+	}
+
+	return p.run("pl_forceresume", "")
+}
+
+// Seek jumps to the position; VLC only supports whole seconds
+func (p *VLC) Seek(position time.Duration) error {
+	return p.run("seek", strconv.FormatInt(int64(position.Round(time.Second).Seconds()), 10))
+}
+
+func (p *VLC) SeekPrecision() time.Duration {
+	return time.Second
+}
+
+func (p *VLC) SetVolume(volume float64) error {
+	return p.run("volume", strconv.Itoa(int(volume*vlcMaxVolume)))
+}
+
+func (p *VLC) SetSpeed(speed float64) error {
+	return p.run("rate", strconv.FormatFloat(speed, 'f', -1, 64))
+}
+
+// SetFullscreen toggles fullscreen if required since VLC can't set it directly
+func (p *VLC) SetFullscreen(fullscreen bool) error {
+	p.stateLock.Lock()
+	current := p.status.Fullscreen
+	p.stateLock.Unlock()
+
+	isFullscreen := false
+	switch value := current.(type) {
+	case bool:
+		isFullscreen = value
+	case float64:
+		isFullscreen = value != 0
+	}
+
+	if isFullscreen == fullscreen {
+		return nil
+	}
+
+	return p.run("fullscreen", "")
+}
+
+func (p *VLC) SetSubtitlesFile(file string) error {
+	return p.run("addsubtitle", (&url.URL{
+		Scheme: "file",
+		Path:   file,
+	}).String())
+}
+
+func (p *VLC) ClearSubtitles() error {
+	return p.run("subtitle_track", "-1")
+}
+
+func (p *VLC) SetSubtitleDelay(delay time.Duration) error {
+	return p.run("subdelay", strconv.FormatFloat(delay.Seconds(), 'f', -1, 64))
+}
+
+// SetSubtitleScale is not supported since VLC can only change the style of subtitles on startup
+func (p *VLC) SetSubtitleScale(scale float64) error {
+	return ErrUnsupported
+}
+
+func (p *VLC) SetSubtitlePosition(position int) error {
+	return ErrUnsupported
+}
+
+func (p *VLC) SetSubtitleFont(font string) error {
+	return ErrUnsupported
+}
+
+func (p *VLC) SelectTrack(trackType string, id int) error {
+	command, ok := vlcTrackCommands[trackType]
+	if !ok || p.rcFile == "" {
+		return ErrUnsupported
+	}
+
+	if err := p.run(command, strconv.Itoa(id-1)); err != nil {
+		return err
+	}
+
+	// The tracks are only listed again once the streams change, so we have to mark them as stale ourselves
+	p.stateLock.Lock()
+	p.choicesStale = true
+	p.stateLock.Unlock()
+
+	return p.poll()
+}
+
+func (p *VLC) ShowText(text string, duration time.Duration) error {
+	return ErrUnsupported
+}
+
+func (p *VLC) SetOverlay(id int, data string) error {
+	return ErrUnsupported
+}
+
+func (p *VLC) RemoveOverlay(id int) error {
+	return ErrUnsupported
+}
+
+func stringField(fields map[string]interface{}, key string) string {
+	value, ok := fields[key].(string)
+	if !ok {
+		return ""
+	}
+
+	return value
+}
+
+// parseVLCChoice parses a track as listed by VLC's command line interface, i.e. `| 2 - Track 1 - [English] *`
+func parseVLCChoice(line string) (vlcChoice, error) {
+	fields := strings.SplitN(strings.TrimPrefix(line, vlcChoicePrefix), " - ", 2)
+	if len(fields) != 2 {
+		return vlcChoice{}, ErrVLCInvalidChoice
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return vlcChoice{}, ErrVLCInvalidChoice
+	}
+
+	return vlcChoice{
+		id:       id,
+		text:     strings.TrimSuffix(fields[1], vlcChoiceSelected),
+		selected: strings.HasSuffix(fields[1], vlcChoiceSelected),
+	}, nil
+}
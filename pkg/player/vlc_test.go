@@ -0,0 +1,79 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVLCListChoices(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	p := NewVLC("vlc", time.Second*5, context.Background())
+	p.rcConn = local
+	p.rcReader = bufio.NewReader(local)
+
+	go func() {
+		reader := bufio.NewReader(remote)
+
+		// Leftovers from other commands are skipped
+		responses := map[string]string{
+			"atrack": "status change: ( audio volume: 256 )\r\n> +----[ Audio Track ]\r\n| -1 - Disable\r\n| 3 - Track 1 - [English] *\r\n| 7 - Track 2 - [Deutsch]\r\n+----[ end of Audio Track ]\r\n",
+			"strack": "> +----[ Subtitle Track ]\r\n| -1 - Disable *\r\n| 0 - Track 1\r\n+----[ end of Subtitle Track ]\r\n",
+			"vtrack": "> +----[ Video Track ]\r\n| broken\r\n+----[ end of Video Track ]\r\n",
+		}
+
+		for {
+			command, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if _, err := remote.Write([]byte(responses[strings.TrimSpace(command)])); err != nil {
+				return
+			}
+		}
+	}()
+
+	tests := []struct {
+		trackType string
+		expected  []vlcChoice
+		valid     bool
+	}{
+		{TrackTypeAudio, []vlcChoice{{id: 3, text: "Track 1 - [English]", selected: true}, {id: 7, text: "Track 2 - [Deutsch]"}}, true},
+		{TrackTypeSubtitles, []vlcChoice{{id: 0, text: "Track 1"}}, true},
+		{TrackTypeVideo, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.trackType, func(t *testing.T) {
+			choices, err := p.listChoices(tt.trackType)
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("accepted invalid track")
+				}
+
+				// The connection is dropped since the rest of the response would be read as the next one
+				if p.rcConn != nil {
+					t.Fatal("connection is still used after invalid response")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(choices, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, choices)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package subtitle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1250 = "windows-1250"
+	EncodingWindows1251 = "windows-1251"
+	EncodingWindows1252 = "windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+
+	// Languages that are usually written in Windows-1250 or Windows-1251 if their subtitles aren't in Unicode
+	centralEuropeanLanguages = map[string]struct{}{"pl": {}, "cs": {}, "hu": {}, "ro": {}, "hr": {}}
+	cyrillicLanguages        = map[string]struct{}{"ru": {}, "uk": {}, "bg": {}, "sr": {}}
+
+	// Bytes that are letters in Windows-1250 but rarely used symbols in Windows-1252, i.e. `ą` and `ł` instead of `¹` and `³`
+	windows1250Letters = []byte{0x8C, 0x8F, 0x9C, 0x9F, 0xA3, 0xA5, 0xB3, 0xB9, 0xBE}
+)
+
+func decodeUTF16(data []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[i*2:])
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
+
+func decodeSingleByte(data []byte, encoding *charmap.Charmap) []byte {
+	// Every byte is a character in single-byte encodings, so decoding can't fail
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+
+	return decoded
+}
+
+// looksLikeUTF16 detects UTF-16 without a BOM from the zero bytes in ASCII characters, which are on the odd bytes in little and on the even bytes in big endian
+func looksLikeUTF16(data []byte) (binary.ByteOrder, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+
+	even, odd := 0, 0
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 {
+			even++
+		}
+
+		if data[i+1] == 0 {
+			odd++
+		}
+	}
+
+	units := len(data) / 2
+	if odd > units/2 && even < units/10 {
+		return binary.LittleEndian, true
+	}
+
+	if even > units/2 && odd < units/10 {
+		return binary.BigEndian, true
+	}
+
+	return nil, false
+}
+
+// guessLegacyEncoding guesses the code page of text that isn't Unicode from the language and the distribution of non-ASCII bytes
+func guessLegacyEncoding(data []byte, language string) string {
+	if _, ok := cyrillicLanguages[language]; ok {
+		return EncodingWindows1251
+	}
+
+	if _, ok := centralEuropeanLanguages[language]; ok {
+		return EncodingWindows1250
+	}
+
+	// Cyrillic text consists almost entirely of non-ASCII letters, while Latin text only has some in between ASCII ones
+	high, adjacent, centralEuropean := 0, 0, 0
+	for i, b := range data {
+		if b < 0x80 {
+			continue
+		}
+
+		high++
+		if i > 0 && data[i-1] >= 0xC0 && b >= 0xC0 {
+			adjacent++
+		}
+
+		if bytes.IndexByte(windows1250Letters, b) != -1 {
+			centralEuropean++
+		}
+	}
+
+	if high == 0 {
+		return EncodingWindows1252
+	}
+
+	if float64(adjacent)/float64(high) > 0.4 {
+		return EncodingWindows1251
+	}
+
+	if float64(centralEuropean)/float64(high) > 0.1 {
+		return EncodingWindows1250
+	}
+
+	return EncodingWindows1252
+}
+
+// ToUTF8 detects the encoding of the subtitles and converts them to UTF-8 without a BOM; the language is used as a hint for legacy encodings
+func ToUTF8(data []byte, language string) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return data[len(bomUTF8):], EncodingUTF8
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return decodeUTF16(data[len(bomUTF16LE):], binary.LittleEndian), EncodingUTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return decodeUTF16(data[len(bomUTF16BE):], binary.BigEndian), EncodingUTF16BE
+	}
+
+	if order, ok := looksLikeUTF16(data); ok {
+		if order == binary.LittleEndian {
+			return decodeUTF16(data, order), EncodingUTF16LE
+		}
+
+		return decodeUTF16(data, order), EncodingUTF16BE
+	}
+
+	if utf8.Valid(data) {
+		return data, EncodingUTF8
+	}
+
+	switch encoding := guessLegacyEncoding(data, language); encoding {
+	case EncodingWindows1250:
+		return decodeSingleByte(data, charmap.Windows1250), encoding
+	case EncodingWindows1251:
+		return decodeSingleByte(data, charmap.Windows1251), encoding
+	default:
+		return decodeSingleByte(data, charmap.Windows1252), encoding
+	}
+}
+
+// isPrintable is used to make sure that we don't try to convert binary files that were selected as subtitles
+func isPrintable(data []byte) bool {
+	control := 0
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			control++
+		}
+	}
+
+	return control <= len(data)/100
+}
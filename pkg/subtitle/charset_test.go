@@ -0,0 +1,36 @@
+package subtitle
+
+import "testing"
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		language string
+		expected string
+		encoding string
+	}{
+		{"UTF-8", []byte("Grüße"), "", "Grüße", EncodingUTF8},
+		{"UTF-8 with BOM", []byte("\xEF\xBB\xBFGrüße"), "", "Grüße", EncodingUTF8},
+		{"UTF-16LE with BOM", []byte("\xFF\xFEH\x00i\x00"), "", "Hi", EncodingUTF16LE},
+		{"UTF-16BE without BOM", []byte("\x00H\x00e\x00l\x00l\x00o\x00 \x00w\x00o\x00r\x00l\x00d"), "", "Hello world", EncodingUTF16BE},
+		{"Windows-1252", []byte("Gr\xFC\xDFe \x80"), "", "Grüße €", EncodingWindows1252},
+		{"Windows-1250 from language", []byte("Za\xBF\xF3\xB3\xE6"), "pl", "Zażółć", EncodingWindows1250},
+		{"Windows-1250 from letters", []byte("Gdzie jest \xB3\xF3d\x9F i \xB9"), "", "Gdzie jest łódź i ą", EncodingWindows1250},
+		{"Windows-1251", []byte("\xCF\xF0\xE8\xE2\xE5\xF2"), "", "Привет", EncodingWindows1251},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, encoding := ToUTF8(tt.data, tt.language)
+
+			if encoding != tt.encoding {
+				t.Fatalf("expected encoding %v, got %v", tt.encoding, encoding)
+			}
+
+			if string(data) != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package subtitle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatSRT     Format = "srt"
+	FormatVTT     Format = "vtt"
+	FormatASS     Format = "ass"
+)
+
+var (
+	ErrUnsupportedConversion = errors.New("can not convert between these subtitle formats")
+	ErrInvalidTimestamp      = errors.New("invalid subtitle timestamp")
+
+	srtTimingExpression = regexp.MustCompile(`^\s*(\d+:\d{2}:\d{2}[,.]\d{1,3})\s*-->\s*(\d+:\d{2}:\d{2}[,.]\d{1,3})`)
+	vttTimingExpression = regexp.MustCompile(`^\s*((?:\d+:)?\d{2}:\d{2}\.\d{3})\s*-->\s*((?:\d+:)?\d{2}:\d{2}\.\d{3})`)
+
+	// VTT supports more tags than SRT, but players only know about the basic ones
+	vttTagExpression   = regexp.MustCompile(`</?(?:c|v|lang|ruby|rt)(?:[.\s][^>]*)?>|<\d+:\d{2}:\d{2}\.\d{3}>|<\d{2}:\d{2}\.\d{3}>`)
+	assBlockExpression = regexp.MustCompile(`{[^}]*}`)
+	assTagExpression   = regexp.MustCompile(`\\([ibu])([01])`)
+)
+
+type cue struct {
+	start int64 // In milliseconds
+	end   int64
+	text  string
+}
+
+// DetectFormat returns the format of the subtitles from their content, which is more reliable than the extension
+func DetectFormat(data []byte) Format {
+	data = bytes.TrimLeft(data, "\uFEFF \t\r\n")
+
+	if bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return FormatVTT
+	}
+
+	if bytes.HasPrefix(data, []byte("[Script Info]")) || bytes.Contains(data, []byte("\n[Events]")) {
+		return FormatASS
+	}
+
+	for _, line := range strings.SplitN(string(data), "\n", 10) {
+		if srtTimingExpression.MatchString(line) {
+			return FormatSRT
+		}
+	}
+
+	return FormatUnknown
+}
+
+// parseTimestamp parses timestamps such as `01:02:03,456` (SRT), `02:03.456` (VTT) and `1:02:03.45` (ASS) into milliseconds
+func parseTimestamp(timestamp string) (int64, error) {
+	timestamp = strings.Replace(strings.TrimSpace(timestamp), ",", ".", 1)
+
+	seconds, fraction, ok := strings.Cut(timestamp, ".")
+	if !ok {
+		return 0, ErrInvalidTimestamp
+	}
+
+	parts := strings.Split(seconds, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, ErrInvalidTimestamp
+	}
+
+	ms := int64(0)
+	for _, part := range parts {
+		value, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, ErrInvalidTimestamp
+		}
+
+		ms = ms*60 + value
+	}
+	ms *= 1000
+
+	// The fraction can be in hundredths (ASS) or thousandths (SRT and VTT) of a second
+	value, err := strconv.ParseInt((fraction + "00")[:3], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidTimestamp
+	}
+
+	return ms + value, nil
+}
+
+func formatTimestamp(ms int64, separator string) string {
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// splitBlocks splits the subtitles into the blocks between empty lines
+func splitBlocks(data string) [][]string {
+	blocks := [][]string{}
+	block := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = []string{}
+			}
+
+			continue
+		}
+
+		block = append(block, line)
+	}
+
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// parseCues parses SRT and VTT cues; blocks without timing such as the VTT header, `NOTE`s and `STYLE`s are skipped
+func parseCues(data string, timing *regexp.Regexp) []cue {
+	cues := []cue{}
+	for _, block := range splitBlocks(data) {
+		for i, line := range block {
+			match := timing.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			start, err := parseTimestamp(match[1])
+			if err != nil {
+				break
+			}
+
+			end, err := parseTimestamp(match[2])
+			if err != nil {
+				break
+			}
+
+			cues = append(cues, cue{start, end, strings.Join(block[i+1:], "\n")})
+
+			break
+		}
+	}
+
+	return cues
+}
+
+func parseASS(data string) []cue {
+	cues := []cue{}
+	fields := []string{}
+	inEvents := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+
+			continue
+		}
+
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "Format":
+			fields = strings.Split(value, ",")
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+		case "Dialogue":
+			// The text is always the last field and can contain commas itself
+			values := strings.SplitN(value, ",", len(fields))
+			if len(fields) == 0 || len(values) != len(fields) {
+				continue
+			}
+
+			c := cue{}
+			valid := true
+			for i, field := range fields {
+				var err error
+				switch field {
+				case "Start":
+					c.start, err = parseTimestamp(values[i])
+				case "End":
+					c.end, err = parseTimestamp(values[i])
+				case "Text":
+					c.text = convertASSText(values[i])
+				}
+
+				if err != nil {
+					valid = false
+				}
+			}
+
+			if valid && strings.TrimSpace(c.text) != "" {
+				cues = append(cues, c)
+			}
+		}
+	}
+
+	// Events don't have to be in order in ASS
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].start < cues[j].start
+	})
+
+	return cues
+}
+
+// convertASSText keeps italic, bold and underlined text and drops other overrides such as positioning and colors
+func convertASSText(text string) string {
+	text = assBlockExpression.ReplaceAllStringFunc(text, func(block string) string {
+		out := ""
+		for _, match := range assTagExpression.FindAllStringSubmatch(block, -1) {
+			if match[2] == "1" {
+				out += "<" + match[1] + ">"
+			} else {
+				out += "</" + match[1] + ">"
+			}
+		}
+
+		return out
+	})
+
+	return strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+}
+
+func writeSRT(cues []cue) []byte {
+	out := bytes.Buffer{}
+	for i, c := range cues {
+		fmt.Fprintf(&out, "%v\n%v --> %v\n%v\n\n", i+1, formatTimestamp(c.start, ","), formatTimestamp(c.end, ","), c.text)
+	}
+
+	return out.Bytes()
+}
+
+func writeVTT(cues []cue) []byte {
+	out := bytes.Buffer{}
+	out.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&out, "%v --> %v\n%v\n\n", formatTimestamp(c.start, "."), formatTimestamp(c.end, "."), c.text)
+	}
+
+	return out.Bytes()
+}
+
+// Convert converts UTF-8 subtitles between formats; converting from ASS drops styling that the other formats don't support
+func Convert(data []byte, from, to Format) ([]byte, error) {
+	if from == to {
+		return data, nil
+	}
+
+	var cues []cue
+	switch from {
+	case FormatSRT:
+		cues = parseCues(string(data), srtTimingExpression)
+	case FormatVTT:
+		cues = parseCues(string(data), vttTimingExpression)
+
+		for i := range cues {
+			cues[i].text = vttTagExpression.ReplaceAllString(cues[i].text, "")
+		}
+	case FormatASS:
+		cues = parseASS(string(data))
+	default:
+		return nil, ErrUnsupportedConversion
+	}
+
+	switch to {
+	case FormatSRT:
+		return writeSRT(cues), nil
+	case FormatVTT:
+		return writeVTT(cues), nil
+	default:
+		return nil, ErrUnsupportedConversion
+	}
+}
+
+// Normalize converts the subtitles to UTF-8 without a BOM and with Unix line endings; VTT is converted to SRT since players don't support most of its cue settings and tags
+func Normalize(data []byte, language string) ([]byte, Format, string, error) {
+	decoded, encoding := ToUTF8(data, language)
+
+	// Files that aren't subtitles, i.e. extra files from the media, are kept as they are; only UTF-16 has to be decoded before checking since half of its bytes are zero
+	printable := data
+	if encoding == EncodingUTF16LE || encoding == EncodingUTF16BE {
+		printable = decoded
+	}
+
+	if !isPrintable(printable) {
+		return data, FormatUnknown, "", nil
+	}
+	data = decoded
+
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+
+	format := DetectFormat(data)
+	if format == FormatVTT {
+		converted, err := Convert(data, FormatVTT, FormatSRT)
+		if err != nil {
+			return nil, FormatUnknown, "", err
+		}
+
+		return converted, FormatSRT, encoding, nil
+	}
+
+	return data, format, encoding, nil
+}
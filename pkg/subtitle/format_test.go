@@ -0,0 +1,36 @@
+package subtitle
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []byte
+		format   Format
+	}{
+		{"SRT with Windows line endings", []byte("1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n"), []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), FormatSRT},
+		{"UTF-16LE SRT", []byte("\xFF\xFE1\x00\n\x000\x000\x00:\x000\x000\x00:\x000\x001\x00,\x000\x000\x000\x00 \x00-\x00-\x00>\x00 \x000\x000\x00:\x000\x000\x00:\x000\x002\x00,\x000\x000\x000\x00\n\x00H\x00i\x00\n\x00"), []byte("1\n00:00:01,000 --> 00:00:02,000\nHi\n"), FormatSRT},
+		{"MPEG", []byte("\x00\x00\x01\xBA\x44\x00\x04\x00\x04\x01\x01\x89\xC3\xF8\x00\x00\x01\xBB\x00\x12\x80\xC4\xE1\x04\xE1\xFF\xB9\xE0\xE8\xB8\xC0\x20\xBD\xE0\x3A\xBF\xE0\x02"), []byte("\x00\x00\x01\xBA\x44\x00\x04\x00\x04\x01\x01\x89\xC3\xF8\x00\x00\x01\xBB\x00\x12\x80\xC4\xE1\x04\xE1\xFF\xB9\xE0\xE8\xB8\xC0\x20\xBD\xE0\x3A\xBF\xE0\x02"), FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, format, _, err := Normalize(tt.data, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if format != tt.format {
+				t.Fatalf("expected format %v, got %v", tt.format, format)
+			}
+
+			if !bytes.Equal(data, tt.expected) {
+				t.Fatalf("expected %q, got %q", tt.expected, data)
+			}
+		})
+	}
+}
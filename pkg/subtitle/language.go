@@ -0,0 +1,138 @@
+package subtitle
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+type language struct {
+	code  string // ISO 639-1
+	name  string
+	alias []string // ISO 639-2 codes and names in the language itself
+}
+
+var (
+	languages = []language{
+		{"en", "English", []string{"eng"}},
+		{"de", "German", []string{"ger", "deu", "deutsch"}},
+		{"fr", "French", []string{"fre", "fra", "francais", "français"}},
+		{"es", "Spanish", []string{"spa", "espanol", "español", "castellano"}},
+		{"it", "Italian", []string{"ita", "italiano"}},
+		{"pt", "Portuguese", []string{"por", "portugues", "português", "brazilian"}},
+		{"nl", "Dutch", []string{"dut", "nld", "nederlands"}},
+		{"sv", "Swedish", []string{"swe", "svenska"}},
+		{"da", "Danish", []string{"dan", "dansk"}},
+		{"no", "Norwegian", []string{"nor", "nob", "norsk"}},
+		{"fi", "Finnish", []string{"fin", "suomi"}},
+		{"pl", "Polish", []string{"pol", "polski"}},
+		{"cs", "Czech", []string{"cze", "ces", "cesky"}},
+		{"hu", "Hungarian", []string{"hun", "magyar"}},
+		{"ro", "Romanian", []string{"rum", "ron", "romana"}},
+		{"hr", "Croatian", []string{"hrv", "hrvatski"}},
+		{"sr", "Serbian", []string{"srp", "srpski"}},
+		{"bg", "Bulgarian", []string{"bul"}},
+		{"ru", "Russian", []string{"rus"}},
+		{"uk", "Ukrainian", []string{"ukr"}},
+		{"el", "Greek", []string{"gre", "ell"}},
+		{"tr", "Turkish", []string{"tur", "turkce"}},
+		{"ar", "Arabic", []string{"ara"}},
+		{"he", "Hebrew", []string{"heb"}},
+		{"fa", "Persian", []string{"per", "fas", "farsi"}},
+		{"hi", "Hindi", []string{"hin"}},
+		{"th", "Thai", []string{"tha"}},
+		{"vi", "Vietnamese", []string{"vie"}},
+		{"id", "Indonesian", []string{"ind"}},
+		{"ja", "Japanese", []string{"jpn"}},
+		{"zh", "Chinese", []string{"chi", "zho", "chs", "cht"}},
+		{"ko", "Korean", []string{"kor"}},
+	}
+
+	// Names and three-letter codes are distinctive enough to be found anywhere in a path
+	languagesByToken = map[string]string{}
+
+	// Two-letter codes are common words, so they are only used if they are a tag in front of the extension, i.e. in `movie.en.srt`
+	languagesByTag = map[string]string{}
+)
+
+func init() {
+	for _, l := range languages {
+		languagesByTag[l.code] = l.code
+		languagesByToken[strings.ToLower(l.name)] = l.code
+
+		for _, alias := range l.alias {
+			languagesByToken[alias] = l.code
+			languagesByTag[alias] = l.code
+		}
+	}
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language of the subtitles at the path, or an empty string if it can't be detected
+func DetectLanguage(p string) string {
+	base := strings.ToLower(path.Base(p))
+
+	// Tags come right before the extension, optionally followed by others such as `forced` or `sdh`, i.e. `movie.en.forced.srt`
+	tags := strings.Split(strings.TrimSuffix(base, path.Ext(base)), ".")
+	for i := len(tags) - 1; i >= 1 && i >= len(tags)-3; i-- {
+		// Tags can include a region, i.e. `pt-br` or `en_us`
+		tag := strings.FieldsFunc(tags[i], func(r rune) bool {
+			return r == '-' || r == '_'
+		})
+		if len(tag) == 0 {
+			continue
+		}
+
+		if code, ok := languagesByTag[tag[0]]; ok {
+			return code
+		}
+	}
+
+	if code, ok := languagesByTag[strings.TrimSuffix(base, path.Ext(base))]; ok {
+		return code
+	}
+
+	// Prefer the file name over the directories, i.e. for `Subs/English/2_German.srt`
+	parts := strings.Split(strings.ToLower(p), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		for _, token := range tokenize(parts[i]) {
+			if code, ok := languagesByToken[token]; ok {
+				return code
+			}
+		}
+	}
+
+	return ""
+}
+
+// LanguageName returns the English name of the language with the ISO 639-1 code
+func LanguageName(code string) string {
+	for _, l := range languages {
+		if l.code == code {
+			return l.name
+		}
+	}
+
+	return code
+}
+
+// SystemLanguage returns the ISO 639-1 code of the user's language from the locale environment variables
+func SystemLanguage() string {
+	for _, key := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := strings.TrimSpace(os.Getenv(key))
+		if value == "" || value == "C" || value == "POSIX" || strings.HasPrefix(value, "C.") {
+			continue
+		}
+
+		// `LANGUAGE` can be a list such as `de_DE:en`, and the others look like `de_DE.UTF-8`
+		fields := strings.FieldsFunc(strings.Split(value, ":")[0], func(r rune) bool {
+			return r == '_' || r == '.' || r == '@' || r == '-'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+
+		return strings.ToLower(fields[0])
+	}
+
+	return ""
+}
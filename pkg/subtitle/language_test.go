@@ -0,0 +1,29 @@
+package subtitle
+
+import "testing"
+
+func TestSystemLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{"LANG", map[string]string{"LANG": "de_DE.UTF-8"}, "de"},
+		{"LANGUAGE list", map[string]string{"LANGUAGE": "pt_BR:en", "LANG": "de_DE.UTF-8"}, "pt"},
+		{"C locale", map[string]string{"LC_ALL": "C.UTF-8", "LANG": "fr_FR.UTF-8"}, "fr"},
+		{"only separators", map[string]string{"LANGUAGE": ":en", "LC_ALL": "_.@", "LANG": "es_ES"}, "es"},
+		{"unset", map[string]string{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			if language := SystemLanguage(); language != tt.expected {
+				t.Fatalf("expected language %q, got %q", tt.expected, language)
+			}
+		})
+	}
+}
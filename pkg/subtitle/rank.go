@@ -0,0 +1,199 @@
+package subtitle
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	similarityWeight = 0.5
+	proximityWeight  = 0.2
+	languageWeight   = 0.3
+
+	// Subtitles for other episodes of a season pack are almost never the ones we want
+	episodeMismatchPenalty = 1
+
+	// MinAutoSelectScore is the score that a candidate needs to have to be selected automatically
+	MinAutoSelectScore = 0.5
+)
+
+var (
+	subtitleExtensions = []string{".srt", ".vtt", ".ass", ".ssa", ".sub"}
+
+	subtitleDirectories = []string{"sub", "subs", "subtitle", "subtitles", "subtitulos", "untertitel"}
+
+	episodeTagExpressions = []*regexp.Regexp{
+		regexp.MustCompile(`s(\d{1,2})[ ._-]?e(\d{1,3})`),
+		// Resolutions such as `1920x1080` aren't episode tags
+		regexp.MustCompile(`(?:^|[^\d])(\d{1,2})x(\d{2,3})(?:[^\d]|$)`),
+	}
+)
+
+// Candidate is a file from the media that could be the subtitles for it
+type Candidate struct {
+	Path     string
+	Language string // ISO 639-1 code; empty if it couldn't be detected
+	Score    float64
+}
+
+// IsSubtitles returns true if the file at the path is a subtitle file
+func IsSubtitles(p string) bool {
+	ext := strings.ToLower(path.Ext(p))
+	for _, candidate := range subtitleExtensions {
+		if ext == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tokenize(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// getEpisodeTag returns a normalized episode tag such as `1x2` for `S01E02`, or an empty string if the name has none
+func getEpisodeTag(name string) string {
+	name = strings.ToLower(name)
+
+	for _, expression := range episodeTagExpressions {
+		if match := expression.FindStringSubmatch(name); match != nil {
+			return strings.TrimLeft(match[1], "0") + "x" + strings.TrimLeft(match[2], "0")
+		}
+	}
+
+	return ""
+}
+
+func withoutExt(p string) string {
+	return strings.TrimSuffix(path.Base(p), path.Ext(p))
+}
+
+// getSimilarity returns the share of tokens that the file names have in common
+func getSimilarity(media, candidate string) float64 {
+	mediaTokens := map[string]struct{}{}
+	for _, token := range tokenize(withoutExt(media)) {
+		mediaTokens[token] = struct{}{}
+	}
+
+	if len(mediaTokens) == 0 {
+		return 0
+	}
+
+	common := 0
+	seen := map[string]struct{}{}
+	for _, token := range tokenize(withoutExt(candidate)) {
+		if _, ok := seen[token]; ok {
+			continue
+		}
+		seen[token] = struct{}{}
+
+		if _, ok := mediaTokens[token]; ok {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(mediaTokens))
+}
+
+func isSubtitleDirectory(name string) bool {
+	name = strings.ToLower(name)
+	for _, candidate := range subtitleDirectories {
+		if name == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getProximity returns how close the candidate is to the media in the directory tree; subtitles are usually next to the media or in a subtitle directory next to it
+func getProximity(media, candidate string) float64 {
+	mediaDir := path.Dir(media)
+	candidateDir := path.Dir(candidate)
+
+	if mediaDir == candidateDir {
+		return 1
+	}
+
+	rel := strings.TrimPrefix(candidateDir, mediaDir+"/")
+	if rel != candidateDir {
+		parts := strings.Split(rel, "/")
+		if isSubtitleDirectory(parts[0]) {
+			// Season packs often have a directory per episode, i.e. `Subs/Show.S01E02/2_English.srt`
+			if len(parts) > 1 && parts[1] == withoutExt(media) {
+				return 1
+			}
+
+			return 0.8
+		}
+
+		return 0.5
+	}
+
+	return 0
+}
+
+// Rank returns the subtitle files from the files sorted by how well they match the media, best first
+func Rank(media string, files []string, preferredLanguage string) []Candidate {
+	preferredLanguage = strings.ToLower(strings.TrimSpace(preferredLanguage))
+	mediaEpisode := getEpisodeTag(path.Base(media))
+
+	candidates := []Candidate{}
+	for _, file := range files {
+		if file == media || !IsSubtitles(file) {
+			continue
+		}
+
+		candidate := Candidate{
+			Path:     file,
+			Language: DetectLanguage(file),
+		}
+
+		candidate.Score = similarityWeight*getSimilarity(media, file) + proximityWeight*getProximity(media, file)
+
+		if preferredLanguage != "" && candidate.Language == preferredLanguage {
+			candidate.Score += languageWeight
+		}
+
+		// The episode tag can also be in the directory, i.e. `Subs/Show.S01E02/English.srt`
+		if mediaEpisode != "" {
+			if episode := getEpisodeTag(file); episode != "" && episode != mediaEpisode {
+				candidate.Score -= episodeMismatchPenalty
+			}
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score == candidates[j].Score {
+			return candidates[i].Path < candidates[j].Path
+		}
+
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	return candidates
+}
+
+// Best returns the best candidate in the preferred language if it matches the media well enough to be selected automatically
+func Best(candidates []Candidate, preferredLanguage string) (Candidate, bool) {
+	preferredLanguage = strings.ToLower(strings.TrimSpace(preferredLanguage))
+	if preferredLanguage == "" {
+		return Candidate{}, false
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Language == preferredLanguage && candidate.Score >= MinAutoSelectScore {
+			return candidate, true
+		}
+	}
+
+	return Candidate{}, false
+}
@@ -0,0 +1,27 @@
+package subtitle
+
+import "testing"
+
+func TestGetEpisodeTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Show.S01E02.720p.mkv", "1x2"},
+		{"Show s1 e12.srt", "1x12"},
+		{"show_s10-e101.srt", "10x101"},
+		{"Show.1x02.srt", "1x2"},
+		{"1x02 - Pilot.srt", "1x2"},
+		{"Movie.1920x1080.mkv", ""},
+		{"Movie.720x480.srt", ""},
+		{"Movie.2019.srt", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tag := getEpisodeTag(tt.name); tag != tt.expected {
+				t.Fatalf("expected episode tag %q, got %q", tt.expected, tag)
+			}
+		})
+	}
+}